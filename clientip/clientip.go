@@ -0,0 +1,180 @@
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-Ip"
+)
+
+type contextKey string
+
+const clientIPKey = contextKey("client_ip")
+
+// WithIP creates a new context with the resolved client IP attached.
+func WithIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+// FromContext returns the client IP stored in the context, or an empty string
+// if no resolver ran for the request.
+func FromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey).(string)
+	return ip
+}
+
+// Resolver resolves the IP of the client which originated a request. The
+// forwarding headers are only honored when the request comes from a trusted
+// proxy, since any client can set them.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// NewResolver creates a Resolver trusting the given proxies, as CIDRs such as
+// "10.0.0.0/8" or single IPs. Without trusted proxies, the remote address of
+// the connection is always used.
+func NewResolver(trustedProxies ...string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, proxy := range trustedProxies {
+		if !strings.Contains(proxy, "/") {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+			}
+			r.trusted = append(r.trusted, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+	return r, nil
+}
+
+// MustNewResolver is like NewResolver but panics on invalid proxies.
+func MustNewResolver(trustedProxies ...string) *Resolver {
+	r, err := NewResolver(trustedProxies...)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Resolve returns the client IP of a request received from remoteAddr, which
+// is either an IP or a host:port pair.
+//
+// When remoteAddr is a trusted proxy, the addresses in the Forwarded header
+// (RFC 7239), or in X-Forwarded-For if absent, are walked from right to left
+// and the first one which isn't a trusted proxy is returned. X-Real-IP is used
+// when neither is present. Unparsable addresses stop the walk, in which case
+// the last trusted hop is returned.
+func (r *Resolver) Resolve(remoteAddr string, header http.Header) string {
+	remote, ok := parseAddr(remoteAddr)
+	if !ok {
+		return hostOf(remoteAddr)
+	}
+	if !r.isTrusted(remote) {
+		return remote.String()
+	}
+
+	chain := forwardedFor(header)
+	if len(chain) == 0 {
+		chain = splitList(header.Values(HeaderXForwardedFor))
+	}
+	if len(chain) == 0 {
+		if ip, ok := parseAddr(header.Get(HeaderXRealIP)); ok {
+			return ip.String()
+		}
+		return remote.String()
+	}
+
+	client := remote
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip, ok := parseAddr(chain[i])
+		if !ok {
+			break
+		}
+		client = ip
+		if !r.isTrusted(ip) {
+			break
+		}
+	}
+	return client.String()
+}
+
+func (r *Resolver) isTrusted(ip netip.Addr) bool {
+	for _, prefix := range r.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the "for" parameters of the Forwarded headers.
+func forwardedFor(header http.Header) []string {
+	var nodes []string
+	for _, element := range splitList(header.Values(HeaderForwarded)) {
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || !strings.EqualFold(key, "for") {
+				continue
+			}
+			nodes = append(nodes, strings.Trim(value, `"`))
+		}
+	}
+	return nodes
+}
+
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// parseAddr parses an IP with an optional port, including the bracketed IPv6
+// form of the Forwarded header, e.g. "[2001:db8::1]:4711".
+func parseAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return netip.Addr{}, false
+	}
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		if addr, err := netip.ParseAddr(s[1 : len(s)-1]); err == nil {
+			return addr.Unmap(), true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,65 @@
+package clientip
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestResolver_Resolve(t *testing.T) {
+	Convey("test client ip resolution", t, func() {
+		resolver := MustNewResolver("10.0.0.0/8", "192.168.1.1", "2001:db8::/32")
+		header := func(kv ...string) http.Header {
+			h := http.Header{}
+			for i := 0; i < len(kv); i += 2 {
+				h.Add(kv[i], kv[i+1])
+			}
+			return h
+		}
+
+		Convey("ignore headers from untrusted peers", func() {
+			ip := resolver.Resolve("203.0.113.9:5555", header(HeaderXForwardedFor, "1.1.1.1", HeaderXRealIP, "1.1.1.1"))
+			So(ip, ShouldEqual, "203.0.113.9")
+		})
+
+		Convey("use remote address without headers", func() {
+			So(resolver.Resolve("10.1.1.1:5555", nil), ShouldEqual, "10.1.1.1")
+		})
+
+		Convey("walk X-Forwarded-For from the right", func() {
+			h := header(HeaderXForwardedFor, "6.6.6.6, 203.0.113.9", HeaderXForwardedFor, "10.2.2.2")
+			So(resolver.Resolve("192.168.1.1:80", h), ShouldEqual, "203.0.113.9")
+		})
+
+		Convey("return the leftmost address if every hop is trusted", func() {
+			h := header(HeaderXForwardedFor, "10.3.3.3, 10.2.2.2")
+			So(resolver.Resolve("10.1.1.1:80", h), ShouldEqual, "10.3.3.3")
+		})
+
+		Convey("prefer the Forwarded header", func() {
+			h := header(
+				HeaderForwarded, `for=198.51.100.17;proto=https, For="[2001:db8:cafe::17]:4711"`,
+				HeaderXForwardedFor, "6.6.6.6",
+			)
+			So(resolver.Resolve("10.1.1.1:80", h), ShouldEqual, "198.51.100.17")
+		})
+
+		Convey("stop at obfuscated identifiers", func() {
+			h := header(HeaderForwarded, "for=198.51.100.17, for=_hidden, for=10.2.2.2")
+			So(resolver.Resolve("10.1.1.1:80", h), ShouldEqual, "10.2.2.2")
+		})
+
+		Convey("fall back to X-Real-IP", func() {
+			So(resolver.Resolve("10.1.1.1:80", header(HeaderXRealIP, "198.51.100.17")), ShouldEqual, "198.51.100.17")
+			So(resolver.Resolve("10.1.1.1:80", header(HeaderXRealIP, "garbage")), ShouldEqual, "10.1.1.1")
+		})
+
+		Convey("reject invalid proxies", func() {
+			_, err := NewResolver("10.0.0.0/33")
+			So(err, ShouldNotBeNil)
+			_, err = NewResolver("localhost")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
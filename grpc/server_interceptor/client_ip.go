@@ -0,0 +1,33 @@
+package server_interceptor
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Genesic/mixednuts/clientip"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientIPServerInterceptor resolves the IP of the client from the peer
+// address and the forwarding metadata, and stores it in the context. See
+// clientip.FromContext.
+func ClientIPServerInterceptor(resolver *clientip.Resolver) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var remoteAddr string
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			remoteAddr = p.Addr.String()
+		}
+
+		header := http.Header{}
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for _, key := range []string{clientip.HeaderForwarded, clientip.HeaderXForwardedFor, clientip.HeaderXRealIP} {
+				header[key] = md.Get(key)
+			}
+		}
+
+		ctx = clientip.WithIP(ctx, resolver.Resolve(remoteAddr, header))
+		return handler(ctx, req)
+	}
+}
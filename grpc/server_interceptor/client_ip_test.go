@@ -0,0 +1,41 @@
+package server_interceptor
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/Genesic/mixednuts/clientip"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestClientIPServerInterceptor(t *testing.T) {
+	Convey("test client ip server interceptor", t, func() {
+		interceptor := ClientIPServerInterceptor(clientip.MustNewResolver("10.0.0.0/8"))
+		resolve := func(peerAddr string, kv ...string) string {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{
+				IP:   net.ParseIP(peerAddr),
+				Port: 5555,
+			}})
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(kv...))
+			var ip string
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				ip = clientip.FromContext(ctx)
+				return nil, nil
+			})
+			So(err, ShouldBeNil)
+			return ip
+		}
+
+		Convey("use forwarding metadata from trusted proxies", func() {
+			So(resolve("10.1.1.1", "x-forwarded-for", "203.0.113.9, 10.2.2.2"), ShouldEqual, "203.0.113.9")
+		})
+
+		Convey("ignore forwarding metadata from untrusted peers", func() {
+			So(resolve("198.51.100.7", "x-forwarded-for", "203.0.113.9"), ShouldEqual, "198.51.100.7")
+		})
+	})
+}
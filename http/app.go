@@ -4,8 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/Genesic/mixednuts/http/middleware"
+	"github.com/Genesic/mixednuts/http/openapi"
 	"github.com/Genesic/mixednuts/logging"
 	"github.com/gorilla/mux"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"net"
 	"net/http"
 	"time"
@@ -20,6 +24,13 @@ type MuxServer struct {
 
 	additionalHandlers map[string]http.Handler
 
+	openAPIPath   string
+	openAPIConfig openapi.Config
+
+	routeTablePath string
+
+	corsPolicies []middleware.CORSPolicy
+
 	port int
 }
 
@@ -27,11 +38,43 @@ type Controller interface {
 	RegisterHandlers(*mux.Router)
 }
 
+// Describer is optionally implemented by a Controller to provide OpenAPI
+// metadata of the routes it registers.
+type Describer interface {
+	DescribeRoutes() []openapi.Route
+}
+
 type Config struct {
 	WriteTimeout time.Duration
 	ReadTimeout  time.Duration
+	// ReadHeaderTimeout protects against slowloris attacks. Defaults to 5s.
+	ReadHeaderTimeout time.Duration
+	// IdleTimeout of keep-alive connections. Defaults to 60s.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes limits the size of request headers. Defaults to
+	// http.DefaultMaxHeaderBytes (1MB).
+	MaxHeaderBytes int
+
+	H2C H2CConfig
+}
+
+// H2CConfig enables HTTP/2 over cleartext TCP, with either prior knowledge or
+// the HTTP/1.1 Upgrade header. HTTP/1.1 requests are still served.
+type H2CConfig struct {
+	Enabled bool
+	// MaxConcurrentStreams is the number of concurrent streams per connection.
+	// Defaults to 250.
+	MaxConcurrentStreams uint32
+	// MaxReadFrameSize is the largest frame the server reads, between 16KB and
+	// 16MB. Defaults to 1MB.
+	MaxReadFrameSize uint32
 }
 
+const (
+	h2MinFrameSize = 1 << 14
+	h2MaxFrameSize = 1<<24 - 1
+)
+
 func NewMuxServer(port int, cfg Config) *MuxServer {
 	if cfg.WriteTimeout.Seconds() <= 0 {
 		cfg.WriteTimeout = 15 * time.Second
@@ -41,6 +84,18 @@ func NewMuxServer(port int, cfg Config) *MuxServer {
 		cfg.ReadTimeout = 15 * time.Second
 	}
 
+	if cfg.ReadHeaderTimeout.Seconds() <= 0 {
+		cfg.ReadHeaderTimeout = 5 * time.Second
+	}
+
+	if cfg.IdleTimeout.Seconds() <= 0 {
+		cfg.IdleTimeout = 60 * time.Second
+	}
+
+	if cfg.MaxHeaderBytes <= 0 {
+		cfg.MaxHeaderBytes = http.DefaultMaxHeaderBytes
+	}
+
 	return &MuxServer{
 		cfg:  cfg,
 		port: port,
@@ -84,12 +139,61 @@ func (s *MuxServer) WithAdditionalHandlers(pathHandlerPair ...interface{}) *MuxS
 	return s
 }
 
-// Serve starts the server.
-func (s *MuxServer) Serve(ctx context.Context) error {
-	if s.server != nil {
-		return errors.New("server initialized and cannot be reused")
+// WithCORS applies CORS policies to the routes, and answers preflight requests
+// of registered routes. See middleware.CORSPolicy.
+func (s *MuxServer) WithCORS(policies ...middleware.CORSPolicy) *MuxServer {
+	s.corsPolicies = append(s.corsPolicies, policies...)
+	return s
+}
+
+// WithOpenAPI serves the OpenAPI document of the registered routes at path.
+func (s *MuxServer) WithOpenAPI(path string, cfg openapi.Config) *MuxServer {
+	s.openAPIPath = path
+	s.openAPIConfig = cfg
+	return s
+}
+
+// OpenAPIDocument builds the OpenAPI document of the registered routes without
+// starting the server, e.g. to compare it with a golden file in tests.
+func (s *MuxServer) OpenAPIDocument() (*openapi.Document, error) {
+	router, _ := s.newRouter()
+	return s.openAPIDocument(router)
+}
+
+// openAPIDocument builds the OpenAPI document of the routes of router.
+func (s *MuxServer) openAPIDocument(router *mux.Router) (*openapi.Document, error) {
+	var routes []openapi.Route
+	for _, controller := range s.controllers {
+		if d, ok := controller.(Describer); ok {
+			routes = append(routes, d.DescribeRoutes()...)
+		}
+	}
+	return openapi.Build(router, s.openAPIConfig, routes)
+}
+
+// openAPIHandler serves the OpenAPI document of router, built once.
+func (s *MuxServer) openAPIHandler(router *mux.Router) (http.Handler, error) {
+	doc, err := s.openAPIDocument(router)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build openapi document: %w", err)
 	}
+	bs, err := doc.JSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode openapi document: %w", err)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(bs)
+	}), nil
+}
+
+// newRouter registers the routes of the controllers and the additional
+// handlers, and returns the source of each route.
+func (s *MuxServer) newRouter() (*mux.Router, routeSources) {
 	rootRouter := mux.NewRouter()
+	sources := make(routeSources)
 
 	// app routes
 	// This comes first before other routes because it's used more frequently.
@@ -97,21 +201,64 @@ func (s *MuxServer) Serve(ctx context.Context) error {
 	appRouter.Use(s.middlewares...)
 	for _, handler := range s.controllers {
 		handler.RegisterHandlers(appRouter)
+		sources.record(appRouter, controllerSource(handler))
 	}
 
 	for path, handler := range s.additionalHandlers {
-		rootRouter.Path(path).Handler(handler)
+		sources[rootRouter.Path(path).Handler(handler)] = routeSourceAdditional
 	}
 
-	s.server = &http.Server{
-		Handler:      rootRouter,
-		Addr:         fmt.Sprintf(":%d", s.port),
-		WriteTimeout: s.cfg.WriteTimeout,
-		ReadTimeout:  s.cfg.ReadTimeout,
-		BaseContext:  func(_ net.Listener) context.Context { return ctx },
+	return rootRouter, sources
+}
+
+// Serve starts the server.
+func (s *MuxServer) Serve(ctx context.Context) error {
+	if s.server != nil {
+		return errors.New("server initialized and cannot be reused")
 	}
+	rootRouter, sources := s.newRouter()
 	logger := logging.FromContext(ctx)
 
+	if s.openAPIPath != "" {
+		handler, err := s.openAPIHandler(rootRouter)
+		if err != nil {
+			return err
+		}
+		rootRouter.Methods(http.MethodGet).Path(s.openAPIPath).Handler(handler)
+	}
+
+	var routeTable []RouteInfo
+	if s.routeTablePath != "" {
+		rootRouter.Methods(http.MethodGet).Path(s.routeTablePath).Handler(routeTableHandler(&routeTable))
+	}
+	routeTable = buildRouteTable(rootRouter, sources, middlewareNames(s.middlewares))
+	logRouteTable(logger, routeTable)
+
+	var handler http.Handler = rootRouter
+	if len(s.corsPolicies) > 0 {
+		handler = middleware.CORS(rootRouter, s.corsPolicies...)(handler)
+	}
+
+	s.server = &http.Server{
+		Handler:           handler,
+		Addr:              fmt.Sprintf(":%d", s.port),
+		WriteTimeout:      s.cfg.WriteTimeout,
+		ReadTimeout:       s.cfg.ReadTimeout,
+		ReadHeaderTimeout: s.cfg.ReadHeaderTimeout,
+		IdleTimeout:       s.cfg.IdleTimeout,
+		MaxHeaderBytes:    s.cfg.MaxHeaderBytes,
+		BaseContext:       func(_ net.Listener) context.Context { return ctx },
+	}
+
+	if s.cfg.H2C.Enabled {
+		if err := s.configureH2C(); err != nil {
+			return err
+		}
+		logger.Infow("h2c enabled",
+			"maxConcurrentStreams", s.cfg.H2C.MaxConcurrentStreams,
+			"maxReadFrameSize", s.cfg.H2C.MaxReadFrameSize)
+	}
+
 	logger.Infow("server starts", "address", s.server.Addr)
 	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.Errorw("failed to start server",
@@ -121,6 +268,29 @@ func (s *MuxServer) Serve(ctx context.Context) error {
 	return nil
 }
 
+// configureH2C wraps the server handler so it accepts HTTP/2 cleartext
+// connections. The h2c handler must be the outermost handler since it takes
+// over the connection before routing.
+func (s *MuxServer) configureH2C() error {
+	cfg := s.cfg.H2C
+	if cfg.MaxReadFrameSize != 0 && (cfg.MaxReadFrameSize < h2MinFrameSize || cfg.MaxReadFrameSize > h2MaxFrameSize) {
+		return fmt.Errorf("h2c max read frame size must be between %d and %d, got %d",
+			h2MinFrameSize, h2MaxFrameSize, cfg.MaxReadFrameSize)
+	}
+
+	h2Server := &http2.Server{
+		MaxConcurrentStreams: cfg.MaxConcurrentStreams,
+		MaxReadFrameSize:     cfg.MaxReadFrameSize,
+		IdleTimeout:          s.server.IdleTimeout,
+	}
+	// Registers the server so graceful shutdown also closes HTTP/2 connections.
+	if err := http2.ConfigureServer(s.server, h2Server); err != nil {
+		return fmt.Errorf("failed to configure h2c: %w", err)
+	}
+	s.server.Handler = h2c.NewHandler(s.server.Handler, h2Server)
+	return nil
+}
+
 func (s *MuxServer) Shutdown(ctx context.Context) error {
 	if s.server == nil {
 		return errors.New("server uninitialized")
@@ -0,0 +1,46 @@
+// Package auth provides the credentials of outbound requests, applied by
+// http.Client to each attempt.
+package auth
+
+import (
+	"context"
+	"net/http"
+)
+
+// Provider sets the credentials of a request. It's called for every attempt,
+// so credentials which expire or depend on the time are always fresh.
+type Provider interface {
+	Authenticate(req *http.Request) error
+}
+
+// Refresher is implemented by providers whose credentials can be renewed.
+// The client refreshes them and retries once when a request results in 401.
+// rejected is the request sent with the credentials the server refused, so
+// when several requests fail at once, a refresher can skip renewing the
+// credentials again once they changed.
+type Refresher interface {
+	Refresh(ctx context.Context, rejected *http.Request) error
+}
+
+// ProviderFunc is an adapter to use a function as a Provider.
+type ProviderFunc func(req *http.Request) error
+
+func (f ProviderFunc) Authenticate(req *http.Request) error {
+	return f(req)
+}
+
+// Bearer sets a static bearer token.
+func Bearer(token string) Provider {
+	return ProviderFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// Basic sets basic authentication credentials.
+func Basic(username, password string) Provider {
+	return ProviderFunc(func(req *http.Request) error {
+		req.SetBasicAuth(username, password)
+		return nil
+	})
+}
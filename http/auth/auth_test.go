@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHMAC(t *testing.T) {
+	Convey("test hmac signing", t, func() {
+		signer := &HMAC{
+			KeyID:  "key-1",
+			Secret: []byte("secret"),
+			Now:    func() time.Time { return time.Unix(1700000000, 0) },
+		}
+		body := `{"id":1}`
+		req, _ := http.NewRequest(http.MethodPost, "http://svc/orders?dry-run=1", strings.NewReader(body))
+		So(signer.Authenticate(req), ShouldBeNil)
+
+		sum := sha256.Sum256([]byte(body))
+		bodyHash := hex.EncodeToString(sum[:])
+		So(req.Header.Get(ContentSHA256Header), ShouldEqual, bodyHash)
+		So(req.Header.Get(TimestampHeader), ShouldEqual, "1700000000")
+		So(req.Header.Get("Authorization"), ShouldEqual, `HMAC-SHA256 keyId="key-1", signature="`+
+			Sign([]byte("secret"), http.MethodPost, "/orders?dry-run=1", "1700000000", bodyHash)+`"`)
+
+		bs, _ := io.ReadAll(req.Body)
+		So(string(bs), ShouldEqual, body)
+
+		Convey("reject bodies which can't be read again", func() {
+			req, _ := http.NewRequest(http.MethodPost, "http://svc/", io.NopCloser(strings.NewReader("stream")))
+			So(signer.Authenticate(req), ShouldEqual, ErrUnsignableBody)
+		})
+	})
+}
+
+func TestClientCredentials(t *testing.T) {
+	Convey("test client credentials token source", t, func() {
+		var issued int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, secret, _ := r.BasicAuth()
+			if id != "client" || secret != "s3cret" || r.PostFormValue("grant_type") != "client_credentials" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+				return
+			}
+			n := atomic.AddInt32(&issued, 1)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token": "token-" + string(rune('0'+n)) + "-" + r.PostFormValue("scope"),
+				"token_type":   "Bearer",
+				"expires_in":   60,
+			})
+		}))
+		defer server.Close()
+
+		source := NewClientCredentials(ClientCredentialsConfig{
+			TokenURL:     server.URL,
+			ClientID:     "client",
+			ClientSecret: "s3cret",
+			Scopes:       []string{"read", "write"},
+		})
+		now := time.Unix(1700000000, 0)
+		source.now = func() time.Time { return now }
+		ctx := context.Background()
+
+		Convey("cache tokens until they are about to expire", func() {
+			token, err := source.Token(ctx)
+			So(err, ShouldBeNil)
+			So(token, ShouldEqual, "token-1-read write")
+
+			now = now.Add(20 * time.Second)
+			token, _ = source.Token(ctx)
+			So(token, ShouldEqual, "token-1-read write")
+
+			// Within the 30s expiry delta.
+			now = now.Add(15 * time.Second)
+			token, _ = source.Token(ctx)
+			So(token, ShouldEqual, "token-2-read write")
+
+			So(source.Refresh(ctx, nil), ShouldBeNil)
+			req, _ := http.NewRequest(http.MethodGet, "http://svc/", nil)
+			So(source.Authenticate(req), ShouldBeNil)
+			So(req.Header.Get("Authorization"), ShouldEqual, "Bearer token-3-read write")
+		})
+
+		Convey("refresh once for requests rejected with the same token", func() {
+			req, _ := http.NewRequest(http.MethodGet, "http://svc/", nil)
+			So(source.Authenticate(req), ShouldBeNil)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_ = source.Refresh(ctx, req)
+				}()
+			}
+			wg.Wait()
+			So(atomic.LoadInt32(&issued), ShouldEqual, 2)
+
+			token, _ := source.Token(ctx)
+			So(token, ShouldEqual, "token-2-read write")
+		})
+
+		Convey("report token endpoint errors", func() {
+			source := NewClientCredentials(ClientCredentialsConfig{TokenURL: server.URL, ClientID: "unknown"})
+			_, err := source.Token(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "invalid_client")
+		})
+	})
+}
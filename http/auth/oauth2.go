@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultExpiryDelta = 30 * time.Second
+
+// ClientCredentialsConfig configures an OAuth2 client credentials token
+// source.
+// See: https://www.rfc-editor.org/rfc/rfc6749#section-4.4
+type ClientCredentialsConfig struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// ExpiryDelta refreshes tokens this long before they expire. Defaults to
+	// 30s.
+	ExpiryDelta time.Duration
+	// HTTPClient requests the tokens. Defaults to a client with a 5s timeout.
+	HTTPClient *http.Client
+}
+
+// TokenSource fetches and caches the access tokens of the client credentials
+// grant. It's a Provider and Refresher, and safe for concurrent use.
+type TokenSource struct {
+	cfg ClientCredentialsConfig
+	now func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func NewClientCredentials(cfg ClientCredentialsConfig) *TokenSource {
+	if cfg.ExpiryDelta <= 0 {
+		cfg.ExpiryDelta = defaultExpiryDelta
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 5 * time.Second}
+	}
+	return &TokenSource{cfg: cfg, now: time.Now}
+}
+
+// Token returns the cached token, or fetches a new one when it's about to
+// expire.
+func (s *TokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && s.now().Add(s.cfg.ExpiryDelta).Before(s.expires) {
+		return s.token, nil
+	}
+	return s.fetchLocked(ctx)
+}
+
+func (s *TokenSource) Authenticate(req *http.Request) error {
+	token, err := s.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Refresh fetches a new token, e.g. after it was revoked. It does nothing when
+// the token was already refreshed since rejected was sent, so concurrent
+// requests failing with the same token cause a single fetch. A nil rejected
+// always fetches a token.
+func (s *TokenSource) Refresh(ctx context.Context, rejected *http.Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rejected != nil && s.token != "" && rejected.Header.Get("Authorization") != "Bearer "+s.token {
+		return nil
+	}
+	_, err := s.fetchLocked(ctx)
+	return err
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (s *TokenSource) fetchLocked(ctx context.Context) (string, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.cfg.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.cfg.ClientID), url.QueryEscape(s.cfg.ClientSecret))
+
+	res, err := s.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("auth: token request failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		bs, _ := io.ReadAll(io.LimitReader(res.Body, 4<<10))
+		return "", fmt.Errorf("auth: token request failed with status %d: %s", res.StatusCode, strings.TrimSpace(string(bs)))
+	}
+
+	var token tokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("auth: failed to parse token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("auth: token response without access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", fmt.Errorf("auth: unsupported token type %q", token.TokenType)
+	}
+
+	s.token = token.AccessToken
+	if token.ExpiresIn > 0 {
+		s.expires = s.now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	} else {
+		// Without expiry, the token is kept until the server rejects it.
+		s.expires = s.now().Add(100 * 365 * 24 * time.Hour)
+	}
+	return s.token, nil
+}
@@ -0,0 +1,158 @@
+package binding
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	tagJSON     = "json"
+	tagForm     = "form"
+	tagQuery    = "query"
+	tagHeader   = "header"
+	tagPath     = "path"
+	tagValidate = "validate"
+
+	defaultMaxMemory = 32 << 20
+)
+
+var fileHeaderType = reflect.TypeOf((*multipart.FileHeader)(nil))
+
+// Binder decodes a request into a tagged struct and validates the result.
+//
+// The body is decoded according to its Content-Type: JSON bodies use the
+// `json` tags, url-encoded and multipart forms use the `form` tags. Query
+// parameters, headers and mux path variables are bound to fields tagged with
+// `query`, `header` and `path` respectively. Multipart files are bound to
+// fields of type *multipart.FileHeader or []*multipart.FileHeader.
+//
+// After binding, the `validate` tags are evaluated, see Validate.
+//
+// Bodies exceeding http.MaxBytesReader return the *http.MaxBytesError as is,
+// so it's answered with 413 by http.HandleError.
+type Binder struct {
+	// DisallowUnknownFields rejects JSON bodies containing fields which don't
+	// exist in the destination struct.
+	DisallowUnknownFields bool
+
+	// MaxMemory is passed to http.Request.ParseMultipartForm. Defaults to 32MB.
+	MaxMemory int64
+}
+
+var defaultBinder = &Binder{}
+
+// Bind decodes r into dst using the default Binder.
+func Bind(r *http.Request, dst interface{}) error {
+	return defaultBinder.Bind(r, dst)
+}
+
+// Bind decodes r into dst, which must be a pointer to a struct, and validates
+// it. Binding and validation failures are returned as *Error, which implements
+// errors.HttpError.
+func (b *Binder) Bind(r *http.Request, dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("binding: expect a non-nil pointer to struct, got %T", dst)
+	}
+
+	if err := b.bindBody(r, dst); err != nil {
+		return err
+	}
+
+	var fieldErrs []FieldError
+	if len(r.URL.RawQuery) > 0 {
+		fieldErrs = append(fieldErrs, bindValues(rv.Elem(), tagQuery, urlValues(r.URL.Query()), nil)...)
+	}
+	if len(r.Header) > 0 {
+		fieldErrs = append(fieldErrs, bindValues(rv.Elem(), tagHeader, headerValues(r.Header), nil)...)
+	}
+	if vars := mux.Vars(r); len(vars) > 0 {
+		fieldErrs = append(fieldErrs, bindValues(rv.Elem(), tagPath, pathValues(vars), nil)...)
+	}
+	if len(fieldErrs) > 0 {
+		return newError("invalid request parameters", fieldErrs)
+	}
+
+	return Validate(dst)
+}
+
+func (b *Binder) bindBody(r *http.Request, dst interface{}) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case "application/json":
+		decoder := json.NewDecoder(r.Body)
+		if b.DisallowUnknownFields {
+			decoder.DisallowUnknownFields()
+		}
+		if err := decoder.Decode(dst); err != nil && err != io.EOF {
+			if isMaxBytesError(err) {
+				return err
+			}
+			return newError(fmt.Sprintf("invalid JSON body: %s", err.Error()), nil)
+		}
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			if isMaxBytesError(err) {
+				return err
+			}
+			return newError(fmt.Sprintf("invalid form body: %s", err.Error()), nil)
+		}
+		if fieldErrs := bindValues(reflect.ValueOf(dst).Elem(), tagForm, urlValues(r.PostForm), nil); len(fieldErrs) > 0 {
+			return newError("invalid form body", fieldErrs)
+		}
+	case "multipart/form-data":
+		maxMemory := b.MaxMemory
+		if maxMemory <= 0 {
+			maxMemory = defaultMaxMemory
+		}
+		if err := r.ParseMultipartForm(maxMemory); err != nil {
+			if isMaxBytesError(err) {
+				return err
+			}
+			return newError(fmt.Sprintf("invalid multipart body: %s", err.Error()), nil)
+		}
+		fieldErrs := bindValues(reflect.ValueOf(dst).Elem(), tagForm, urlValues(r.MultipartForm.Value), r.MultipartForm.File)
+		if len(fieldErrs) > 0 {
+			return newError("invalid multipart body", fieldErrs)
+		}
+	}
+
+	return nil
+}
+
+// isMaxBytesError reports whether the body exceeded http.MaxBytesReader, which
+// should be answered with 413 rather than a binding error.
+func isMaxBytesError(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// headerValues canonicalizes header keys so tags can be written in any case.
+type headerValues http.Header
+
+func (h headerValues) get(key string) ([]string, bool) {
+	v, ok := h[http.CanonicalHeaderKey(key)]
+	return v, ok
+}
+
+type pathValues map[string]string
+
+func (p pathValues) get(key string) ([]string, bool) {
+	v, ok := p[key]
+	if !ok {
+		return nil, false
+	}
+	return []string{v}, true
+}
@@ -0,0 +1,142 @@
+package binding
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type address struct {
+	City string `json:"city" form:"city" validate:"required"`
+	Zip  string `json:"zip" form:"zip" validate:"regex=^[0-9]{5}$"`
+}
+
+type createUser struct {
+	ID        int           `path:"id" validate:"min=1"`
+	RequestID string        `header:"x-request-id"`
+	Verbose   bool          `query:"verbose"`
+	Tags      []string      `query:"tag" validate:"max=2"`
+	Timeout   time.Duration `query:"timeout"`
+	Name      string        `json:"name" form:"name" validate:"required,min=2,max=8"`
+	Role      string        `json:"role" form:"role" validate:"enum=admin|member"`
+	Address   *address      `json:"address" validate:"required"`
+}
+
+func newRequest(method, target, contentType string, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	return r
+}
+
+func TestBind(t *testing.T) {
+	Convey("test binding", t, func() {
+		Convey("bind JSON body, query, header and path", func() {
+			r := newRequest(http.MethodPost, "/users/3?verbose=true&tag=a&tag=b&timeout=2s", "application/json",
+				`{"name":"gopher","role":"admin","address":{"city":"Taipei","zip":"10617"}}`)
+			r.Header.Set("X-Request-Id", "abc")
+			r = mux.SetURLVars(r, map[string]string{"id": "3"})
+
+			var req createUser
+			So(Bind(r, &req), ShouldBeNil)
+			So(req.ID, ShouldEqual, 3)
+			So(req.RequestID, ShouldEqual, "abc")
+			So(req.Verbose, ShouldBeTrue)
+			So(req.Tags, ShouldResemble, []string{"a", "b"})
+			So(req.Timeout, ShouldEqual, 2*time.Second)
+			So(req.Name, ShouldEqual, "gopher")
+			So(req.Address.City, ShouldEqual, "Taipei")
+		})
+
+		Convey("bind url-encoded form with nested struct", func() {
+			form := url.Values{}
+			form.Set("name", "gopher")
+			form.Set("city", "Taipei")
+			r := newRequest(http.MethodPost, "/users", "application/x-www-form-urlencoded", form.Encode())
+
+			var req createUser
+			So(Bind(r, &req), ShouldBeNil)
+			So(req.Name, ShouldEqual, "gopher")
+			So(req.Address, ShouldNotBeNil)
+			So(req.Address.City, ShouldEqual, "Taipei")
+		})
+
+		Convey("bind multipart form with files", func() {
+			var buf bytes.Buffer
+			mw := multipart.NewWriter(&buf)
+			_ = mw.WriteField("title", "report")
+			fw, _ := mw.CreateFormFile("file", "report.csv")
+			_, _ = fw.Write([]byte("a,b,c"))
+			_ = mw.Close()
+			r := newRequest(http.MethodPost, "/upload", mw.FormDataContentType(), buf.String())
+
+			var req struct {
+				Title string                `form:"title" validate:"required"`
+				File  *multipart.FileHeader `form:"file" validate:"required"`
+			}
+			So(Bind(r, &req), ShouldBeNil)
+			So(req.Title, ShouldEqual, "report")
+			So(req.File.Filename, ShouldEqual, "report.csv")
+		})
+
+		Convey("report every field violation", func() {
+			r := newRequest(http.MethodPost, "/users?tag=a&tag=b&tag=c", "application/json",
+				`{"name":"g","role":"guest"}`)
+
+			var req createUser
+			err := Bind(r, &req)
+			So(err, ShouldHaveSameTypeAs, &Error{})
+			bindErr := err.(*Error)
+			So(bindErr.GetCode(), ShouldEqual, http.StatusBadRequest)
+			So(bindErr.Fields, ShouldResemble, []FieldError{
+				{Field: "tag", Rule: "max", Message: "length must be at most 2"},
+				{Field: "name", Rule: "min", Message: "length must be at least 2"},
+				{Field: "role", Rule: "enum", Message: "must be one of [admin, member]"},
+				{Field: "address", Rule: "required", Message: "is required"},
+			})
+		})
+
+		Convey("validate nested structs", func() {
+			r := newRequest(http.MethodPost, "/users", "application/json",
+				`{"name":"gopher","address":{"zip":"abc"}}`)
+
+			var req createUser
+			err := Bind(r, &req).(*Error)
+			So(err.Fields, ShouldResemble, []FieldError{
+				{Field: "address.city", Rule: "required", Message: "is required"},
+				{Field: "address.zip", Rule: "regex", Message: "must match ^[0-9]{5}$"},
+			})
+		})
+
+		Convey("report type errors", func() {
+			r := newRequest(http.MethodGet, "/users?verbose=maybe", "", "")
+
+			var req createUser
+			err := Bind(r, &req).(*Error)
+			So(err.Fields, ShouldResemble, []FieldError{
+				{Field: "verbose", Rule: "type", Message: `invalid boolean "maybe"`},
+			})
+		})
+
+		Convey("reject unknown JSON fields", func() {
+			body := `{"name":"gopher","address":{"city":"Taipei"},"admin":true}`
+			binder := &Binder{DisallowUnknownFields: true}
+
+			var req createUser
+			So(Bind(newRequest(http.MethodPost, "/users", "application/json", body), &req), ShouldBeNil)
+			err := binder.Bind(newRequest(http.MethodPost, "/users", "application/json", body), &req)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, `unknown field "admin"`)
+		})
+	})
+}
@@ -0,0 +1,221 @@
+package binding
+
+import (
+	"encoding"
+	"fmt"
+	"mime/multipart"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+type valueGetter interface {
+	get(key string) ([]string, bool)
+}
+
+type urlValues url.Values
+
+func (u urlValues) get(key string) ([]string, bool) {
+	v, ok := u[key]
+	return v, ok
+}
+
+// DecodeForm sets the fields of the struct pointed to by dst tagged with
+// `form` from values, as the form binding does, without validating them.
+func DecodeForm(values url.Values, dst interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("binding: DecodeForm expects a pointer to a struct, got %T", dst)
+	}
+	if fieldErrs := bindValues(rv.Elem(), tagForm, urlValues(values), nil); len(fieldErrs) > 0 {
+		return newError("invalid form", fieldErrs)
+	}
+	return nil
+}
+
+// bindValues sets the fields of v tagged with tag from values. Struct fields
+// without the tag are traversed so nested and embedded structs can be bound.
+func bindValues(v reflect.Value, tag string, values valueGetter, files map[string][]*multipart.FileHeader) []FieldError {
+	return bindStruct(v, tag, values, files, "")
+}
+
+func bindStruct(v reflect.Value, tag string, values valueGetter, files map[string][]*multipart.FileHeader, prefix string) []FieldError {
+	var fieldErrs []FieldError
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+
+		name, ok := tagName(sf, tag)
+		if !ok {
+			if isNestedStruct(sf.Type) {
+				fieldErrs = append(fieldErrs, bindNested(fv, tag, values, files, prefix+fieldName(sf)+".")...)
+			}
+			continue
+		}
+
+		if files != nil && (sf.Type == fileHeaderType || sf.Type == reflect.SliceOf(fileHeaderType)) {
+			if fhs := files[name]; len(fhs) > 0 {
+				if sf.Type == fileHeaderType {
+					fv.Set(reflect.ValueOf(fhs[0]))
+				} else {
+					fv.Set(reflect.ValueOf(fhs))
+				}
+			}
+			continue
+		}
+
+		raw, ok := values.get(name)
+		if !ok || len(raw) == 0 {
+			continue
+		}
+		if err := setField(fv, raw); err != nil {
+			fieldErrs = append(fieldErrs, FieldError{
+				Field:   prefix + name,
+				Rule:    "type",
+				Message: err.Error(),
+			})
+		}
+	}
+	return fieldErrs
+}
+
+func bindNested(fv reflect.Value, tag string, values valueGetter, files map[string][]*multipart.FileHeader, prefix string) []FieldError {
+	if fv.Kind() != reflect.Ptr {
+		return bindStruct(fv, tag, values, files, prefix)
+	}
+	if !fv.IsNil() {
+		return bindStruct(fv.Elem(), tag, values, files, prefix)
+	}
+
+	// Only allocate nil pointers when something is bound, so a missing nested
+	// struct still fails the required rule.
+	ptr := reflect.New(fv.Type().Elem())
+	fieldErrs := bindStruct(ptr.Elem(), tag, values, files, prefix)
+	if !ptr.Elem().IsZero() {
+		fv.Set(ptr)
+	}
+	return fieldErrs
+}
+
+func isNestedStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return false
+	}
+	return !reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// tagName returns the name of the field in the given tag, ignoring options
+// such as ",omitempty".
+func tagName(sf reflect.StructField, tag string) (string, bool) {
+	value, ok := sf.Tag.Lookup(tag)
+	if !ok {
+		return "", false
+	}
+	name := strings.Split(value, ",")[0]
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = sf.Name
+	}
+	return name, true
+}
+
+// fieldName is the name used to report violations of sf, preferring the json
+// tag since it's what most clients see.
+func fieldName(sf reflect.StructField) string {
+	for _, tag := range []string{tagJSON, tagForm, tagQuery, tagHeader, tagPath} {
+		if name, ok := tagName(sf, tag); ok {
+			return name
+		}
+	}
+	return sf.Name
+}
+
+func setField(fv reflect.Value, raw []string) error {
+	if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(fv.Type(), len(raw), len(raw))
+		for i, s := range raw {
+			if err := setValue(slice.Index(i), s); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+	return setValue(fv, raw[0])
+}
+
+func setValue(fv reflect.Value, s string) error {
+	if fv.Kind() == reflect.Ptr {
+		ptr := reflect.New(fv.Type().Elem())
+		if err := setValue(ptr.Elem(), s); err != nil {
+			return err
+		}
+		fv.Set(ptr)
+		return nil
+	}
+
+	if fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", s)
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		// []byte
+		fv.SetBytes([]byte(s))
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return nil
+}
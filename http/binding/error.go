@@ -0,0 +1,49 @@
+package binding
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// FieldError describes a single field which failed binding or validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error is returned by Bind and Validate. It implements errors.HttpError, so
+// it can be returned from an http.HandlerFunc as is.
+type Error struct {
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+func newError(msg string, fields []FieldError) *Error {
+	return &Error{
+		Message: msg,
+		Fields:  fields,
+	}
+}
+
+func (e *Error) Error() string {
+	if len(e.Fields) == 0 {
+		return e.Message
+	}
+	var violations []string
+	for _, f := range e.Fields {
+		violations = append(violations, fmt.Sprintf("%s %s", f.Field, f.Message))
+	}
+	return fmt.Sprintf("%s: %s", e.Message, strings.Join(violations, "; "))
+}
+
+func (e *Error) GetCode() int {
+	return http.StatusBadRequest
+}
+
+func (e *Error) GetMessage() string {
+	bs, _ := json.Marshal(e)
+	return string(bs)
+}
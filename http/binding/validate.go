@@ -0,0 +1,277 @@
+package binding
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	ruleRequired = "required"
+	ruleMin      = "min"
+	ruleMax      = "max"
+	ruleRegex    = "regex"
+	ruleEnum     = "enum"
+)
+
+var regexCache sync.Map
+
+type rule struct {
+	name  string
+	param string
+}
+
+// Validate evaluates the `validate` tags of the struct pointed by v, e.g.
+//
+//	type CreateUser struct {
+//		Name    string   `json:"name" validate:"required,min=2,max=32"`
+//		Role    string   `json:"role" validate:"enum=admin|member"`
+//		Email   string   `json:"email" validate:"regex=^[^@]+@[^@]+$"`
+//		Address *Address `json:"address" validate:"required"`
+//	}
+//
+// Supported rules are:
+//   - required: the value must not be the zero value.
+//   - min=N, max=N: bounds of numbers, or of the length of strings, slices and maps.
+//   - enum=a|b|c: the value must be one of the listed values.
+//   - regex=EXPR: strings must match EXPR. Since EXPR may contain commas, it
+//     must be the last rule of the tag.
+//
+// Rules other than required are only checked for non-zero values. Nested
+// structs, pointers to structs and slices of structs are validated
+// recursively. Violations are returned as *Error.
+func Validate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("binding: expect a struct, got %T", v)
+	}
+
+	fieldErrs, err := validateStruct(rv, "")
+	if err != nil {
+		return err
+	}
+	if len(fieldErrs) > 0 {
+		return newError("validation failed", fieldErrs)
+	}
+	return nil
+}
+
+func validateStruct(v reflect.Value, prefix string) ([]FieldError, error) {
+	var fieldErrs []FieldError
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		name := prefix + fieldName(sf)
+		if sf.Anonymous {
+			name = strings.TrimSuffix(prefix, ".")
+		}
+
+		rules, err := parseRules(sf.Tag.Get(tagValidate))
+		if err != nil {
+			return nil, fmt.Errorf("binding: field %s: %w", sf.Name, err)
+		}
+		fieldErr, err := validateField(fv, name, rules)
+		if err != nil {
+			return nil, fmt.Errorf("binding: field %s: %w", sf.Name, err)
+		}
+		if fieldErr != nil {
+			fieldErrs = append(fieldErrs, *fieldErr)
+			continue
+		}
+
+		nestedErrs, err := validateNested(fv, name, sf.Anonymous)
+		if err != nil {
+			return nil, err
+		}
+		fieldErrs = append(fieldErrs, nestedErrs...)
+	}
+	return fieldErrs, nil
+}
+
+func validateNested(fv reflect.Value, name string, anonymous bool) ([]FieldError, error) {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return nil, nil
+		}
+		fv = fv.Elem()
+	}
+
+	prefix := name + "."
+	if anonymous && name == "" {
+		prefix = ""
+	}
+
+	switch fv.Kind() {
+	case reflect.Struct:
+		if !isNestedStruct(fv.Type()) {
+			return nil, nil
+		}
+		return validateStruct(fv, prefix)
+	case reflect.Slice, reflect.Array:
+		elemType := fv.Type().Elem()
+		if !isNestedStruct(elemType) {
+			return nil, nil
+		}
+		var fieldErrs []FieldError
+		for i := 0; i < fv.Len(); i++ {
+			errs, err := validateNested(fv.Index(i), fmt.Sprintf("%s[%d]", name, i), false)
+			if err != nil {
+				return nil, err
+			}
+			fieldErrs = append(fieldErrs, errs...)
+		}
+		return fieldErrs, nil
+	}
+	return nil, nil
+}
+
+func parseRules(tag string) ([]rule, error) {
+	var rules []rule
+	for tag != "" {
+		var part string
+		if strings.HasPrefix(tag, ruleRegex+"=") {
+			part, tag = tag, ""
+		} else if idx := strings.Index(tag, ","); idx >= 0 {
+			part, tag = tag[:idx], tag[idx+1:]
+		} else {
+			part, tag = tag, ""
+		}
+		if part == "" {
+			continue
+		}
+
+		name, param, _ := strings.Cut(part, "=")
+		switch name {
+		case ruleRequired:
+		case ruleMin, ruleMax:
+			if _, err := strconv.ParseFloat(param, 64); err != nil {
+				return nil, fmt.Errorf("invalid %s parameter %q", name, param)
+			}
+		case ruleRegex:
+			if _, err := compileRegex(param); err != nil {
+				return nil, err
+			}
+		case ruleEnum:
+			if param == "" {
+				return nil, fmt.Errorf("empty enum")
+			}
+		default:
+			return nil, fmt.Errorf("unknown validation rule %q", name)
+		}
+		rules = append(rules, rule{name: name, param: param})
+	}
+	return rules, nil
+}
+
+func validateField(fv reflect.Value, name string, rules []rule) (*FieldError, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	isZero := fv.IsZero()
+	for _, r := range rules {
+		if r.name == ruleRequired {
+			if isZero {
+				return &FieldError{Field: name, Rule: r.name, Message: "is required"}, nil
+			}
+			continue
+		}
+		if isZero {
+			continue
+		}
+
+		v := fv
+		for v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+
+		var msg string
+		switch r.name {
+		case ruleMin, ruleMax:
+			msg = checkBound(v, r)
+		case ruleRegex:
+			re, _ := compileRegex(r.param)
+			if v.Kind() != reflect.String {
+				return nil, fmt.Errorf("regex rule on non-string type %s", v.Type())
+			}
+			if !re.MatchString(v.String()) {
+				msg = fmt.Sprintf("must match %s", r.param)
+			}
+		case ruleEnum:
+			if !inEnum(v, strings.Split(r.param, "|")) {
+				msg = fmt.Sprintf("must be one of [%s]", strings.ReplaceAll(r.param, "|", ", "))
+			}
+		}
+		if msg != "" {
+			return &FieldError{Field: name, Rule: r.name, Message: msg}, nil
+		}
+	}
+	return nil, nil
+}
+
+func checkBound(v reflect.Value, r rule) string {
+	bound, _ := strconv.ParseFloat(r.param, 64)
+
+	var actual float64
+	unit := ""
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		actual = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		actual = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		actual = v.Float()
+	case reflect.String:
+		actual = float64(utf8.RuneCountInString(v.String()))
+		unit = "length "
+	case reflect.Slice, reflect.Map, reflect.Array:
+		actual = float64(v.Len())
+		unit = "length "
+	default:
+		return ""
+	}
+
+	if r.name == ruleMin && actual < bound {
+		return fmt.Sprintf("%smust be at least %s", unit, r.param)
+	}
+	if r.name == ruleMax && actual > bound {
+		return fmt.Sprintf("%smust be at most %s", unit, r.param)
+	}
+	return ""
+}
+
+func inEnum(v reflect.Value, values []string) bool {
+	s := fmt.Sprint(v.Interface())
+	for _, value := range values {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %w", expr, err)
+	}
+	regexCache.Store(expr, re)
+	return re, nil
+}
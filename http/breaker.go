@@ -0,0 +1,315 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Genesic/mixednuts/logging"
+	"github.com/Genesic/mixednuts/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultBreakerConsecutiveFailures = 5
+	defaultBreakerMinRequests         = 10
+	defaultBreakerWindow              = time.Minute
+	minBreakerWindow                  = time.Second
+	defaultBreakerOpenTimeout         = 30 * time.Second
+	defaultBreakerHalfOpenRequests    = 1
+	// breakerBuckets is the number of buckets of the rolling window.
+	breakerBuckets = 10
+)
+
+// ErrCircuitOpen is matched by errors.Is for every CircuitOpenError.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitOpenError is returned without sending the request while the circuit
+// of its host is open.
+type CircuitOpenError struct {
+	Host string
+	// Until is when the circuit becomes half-open and lets a probe through.
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker is open for %s until %s", e.Host, e.Until.Format(time.RFC3339))
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitState is the state of the circuit of a host.
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through and counts failures.
+	CircuitClosed CircuitState = iota
+	// CircuitHalfOpen lets a limited number of probes through. The circuit
+	// closes once they succeed and opens again on the first failure.
+	CircuitHalfOpen
+	// CircuitOpen fails requests fast until OpenTimeout elapses.
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitHalfOpen:
+		return "half-open"
+	case CircuitOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerConfig configures the circuit breaker of a Client. Each host
+// has its own circuit, which trips on ConsecutiveFailures or on FailureRatio,
+// whichever comes first.
+type CircuitBreakerConfig struct {
+	// ConsecutiveFailures trips the circuit after this many failures in a row.
+	// Defaults to 5 when FailureRatio is not set, negative disables it.
+	ConsecutiveFailures int
+	// FailureRatio trips the circuit when the ratio of failures over Window
+	// reaches it, once there were at least MinRequests requests. Zero disables
+	// it. MinRequests defaults to 10 and Window to 1 minute, with 1 second
+	// as minimum.
+	FailureRatio float64
+	MinRequests  int
+	Window       time.Duration
+	// OpenTimeout is how long the circuit stays open. Defaults to 30s.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of probes needed to close the circuit.
+	// Defaults to 1.
+	HalfOpenRequests int
+	// IsFailure reports whether an attempt counts as a failure. By default
+	// transport errors and 5xx responses are failures.
+	IsFailure func(*http.Response, error) bool
+	// Registerer registers the state metrics. Defaults to
+	// prometheus.DefaultRegisterer.
+	Registerer prometheus.Registerer
+}
+
+func (cfg CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if cfg.ConsecutiveFailures == 0 && cfg.FailureRatio == 0 {
+		cfg.ConsecutiveFailures = defaultBreakerConsecutiveFailures
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = defaultBreakerMinRequests
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = defaultBreakerWindow
+	}
+	if cfg.Window < minBreakerWindow {
+		cfg.Window = minBreakerWindow
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = defaultBreakerHalfOpenRequests
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = func(res *http.Response, err error) bool {
+			return err != nil || res.StatusCode >= http.StatusInternalServerError
+		}
+	}
+	if cfg.Registerer == nil {
+		cfg.Registerer = prometheus.DefaultRegisterer
+	}
+	return cfg
+}
+
+// WithCircuitBreaker fails requests fast while their host is failing. Each
+// attempt of the retry policy goes through the breaker, and an open circuit
+// is not retried.
+func (c *Client) WithCircuitBreaker(cfg CircuitBreakerConfig) *Client {
+	c = c.clone()
+	c.breaker = newCircuitBreaker(cfg.withDefaults())
+	return c
+}
+
+type breakerMetrics struct {
+	state       *prometheus.GaugeVec
+	transitions *prometheus.CounterVec
+}
+
+func newBreakerMetrics(reg prometheus.Registerer) *breakerMetrics {
+	return &breakerMetrics{
+		state: utils.RegisterCollector(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_client_circuit_breaker_state",
+			Help: "State of the http client circuit breaker by host: 0 closed, 1 half-open, 2 open.",
+		}, []string{"host"})),
+		transitions: utils.RegisterCollector(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_circuit_breaker_transitions_total",
+			Help: "Total number of http client circuit breaker state transitions.",
+		}, []string{"host", "from", "to"})),
+	}
+}
+
+type circuitBreaker struct {
+	cfg     CircuitBreakerConfig
+	metrics *breakerMetrics
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+type circuit struct {
+	state CircuitState
+	// generation changes on each transition, so results of requests allowed
+	// in a previous state are ignored.
+	generation uint64
+	openedAt   time.Time
+
+	consecutiveFailures int
+	buckets             [breakerBuckets]breakerBucket
+
+	probes    int
+	successes int
+}
+
+type breakerBucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+func newCircuitBreaker(cfg CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{
+		cfg:     cfg,
+		metrics: newBreakerMetrics(cfg.Registerer),
+		hosts:   make(map[string]*circuit),
+	}
+}
+
+// allow reports whether a request to host can be sent, and returns the
+// generation to pass to done.
+func (b *circuitBreaker) allow(req *http.Request, now time.Time) (uint64, error) {
+	host := req.URL.Host
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.hosts[host]
+	if !ok {
+		c = &circuit{}
+		b.hosts[host] = c
+		b.metrics.state.WithLabelValues(host).Set(float64(CircuitClosed))
+	}
+
+	switch c.state {
+	case CircuitOpen:
+		until := c.openedAt.Add(b.cfg.OpenTimeout)
+		if now.Before(until) {
+			return 0, &CircuitOpenError{Host: host, Until: until}
+		}
+		b.transition(req, c, CircuitHalfOpen, now)
+		fallthrough
+	case CircuitHalfOpen:
+		if c.probes+c.successes >= b.cfg.HalfOpenRequests {
+			return 0, &CircuitOpenError{Host: host, Until: now}
+		}
+		c.probes++
+	}
+	return c.generation, nil
+}
+
+// done records the result of a request allowed in generation.
+func (b *circuitBreaker) done(req *http.Request, generation uint64, res *http.Response, err error, now time.Time) {
+	failure := b.cfg.IsFailure(res, err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.hosts[req.URL.Host]
+	if c.generation != generation {
+		return
+	}
+	if canceled(req, err) {
+		// Says nothing about the host, such as a hedge which lost.
+		if c.state == CircuitHalfOpen {
+			c.probes--
+		}
+		return
+	}
+
+	switch c.state {
+	case CircuitClosed:
+		bucket := c.bucket(now, b.cfg.Window)
+		if failure {
+			bucket.failures++
+			c.consecutiveFailures++
+		} else {
+			bucket.successes++
+			c.consecutiveFailures = 0
+		}
+		if b.shouldTrip(c, now) {
+			b.transition(req, c, CircuitOpen, now)
+		}
+	case CircuitHalfOpen:
+		c.probes--
+		if failure {
+			b.transition(req, c, CircuitOpen, now)
+			return
+		}
+		c.successes++
+		if c.successes >= b.cfg.HalfOpenRequests {
+			b.transition(req, c, CircuitClosed, now)
+		}
+	}
+}
+
+func (b *circuitBreaker) shouldTrip(c *circuit, now time.Time) bool {
+	if b.cfg.ConsecutiveFailures > 0 && c.consecutiveFailures >= b.cfg.ConsecutiveFailures {
+		return true
+	}
+	if b.cfg.FailureRatio <= 0 {
+		return false
+	}
+	var total, failures int
+	for _, bucket := range c.buckets {
+		if now.Sub(bucket.start) < b.cfg.Window {
+			total += bucket.successes + bucket.failures
+			failures += bucket.failures
+		}
+	}
+	return total >= b.cfg.MinRequests && float64(failures)/float64(total) >= b.cfg.FailureRatio
+}
+
+func (b *circuitBreaker) transition(req *http.Request, c *circuit, to CircuitState, now time.Time) {
+	from := c.state
+	*c = circuit{state: to, generation: c.generation + 1}
+	if to == CircuitOpen {
+		c.openedAt = now
+	}
+
+	host := req.URL.Host
+	b.metrics.state.WithLabelValues(host).Set(float64(to))
+	b.metrics.transitions.WithLabelValues(host, from.String(), to.String()).Inc()
+	logging.FromContext(req.Context()).Warnw("circuit breaker state changed",
+		"host", host,
+		"from", from.String(),
+		"to", to.String())
+}
+
+// bucket returns the bucket of the rolling window for now, resetting it if it
+// belongs to a previous window.
+func (c *circuit) bucket(now time.Time, window time.Duration) *breakerBucket {
+	width := window / breakerBuckets
+	start := now.Truncate(width)
+	bucket := &c.buckets[(start.UnixNano()/int64(width))%breakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
+
+// canceled reports whether an attempt failed because its context was
+// canceled rather than because of the host.
+func canceled(req *http.Request, err error) bool {
+	return err != nil && errors.Is(req.Context().Err(), context.Canceled)
+}
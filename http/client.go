@@ -2,6 +2,7 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,13 +11,50 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/Genesic/mixednuts/http/auth"
+	"github.com/Genesic/mixednuts/http/codec"
+	"github.com/Genesic/mixednuts/http/transport"
+	"github.com/Genesic/mixednuts/logging"
 )
 
+// Client calls the http APIs of a service. Its With* methods return a copy of
+// the client, sharing the transport and its connections, so clients can be
+// derived per call site without affecting each other:
+//
+//	base := http.NewClient("users:8080").WithRetryPolicy(http.DefaultRetryPolicy())
+//	admin := base.WithHeaders("X-Role", "admin")
+//
+// Request headers are set in increasing precedence from the headers of the
+// client, the headers argument of the call, the Header request option, and
+// finally the headers derived from the call itself, such as the Content-Type
+// of the body and the credentials of the auth provider.
 type Client struct {
 	*http.Client
 
-	headers map[string]string
-	baseURL string
+	headers     http.Header
+	baseURL     string
+	retryPolicy RetryPolicy
+	breaker     *circuitBreaker
+	auth        auth.Provider
+	codecs      *codec.Registry
+	pool        *EndpointPool
+	hedger      *hedger
+	// errorPayload returns the value the body of ResponseError is decoded to.
+	errorPayload func() interface{}
+	// base is the transport the round-trippers are chained on,
+	// http.DefaultTransport unless set with WithTransport or by assigning the
+	// Transport of the client.
+	base http.RoundTripper
+	// roundTrippers wrap the transport after the built-in request ID
+	// round-tripper.
+	roundTrippers []transport.Middleware
+}
+
+// chainedTransport marks the transport built by the client, to tell it apart
+// from a transport assigned by the caller.
+type chainedTransport struct {
+	http.RoundTripper
 }
 
 func NewClient(baseURL string) *Client {
@@ -24,16 +62,65 @@ func NewClient(baseURL string) *Client {
 		baseURL = "http://" + baseURL
 	}
 
-	return &Client{
+	c := &Client{
 		Client: &http.Client{
 			Timeout: 5 * time.Second,
 		},
-		headers: make(map[string]string),
+		headers: make(http.Header),
 		baseURL: baseURL,
+		codecs:  codec.Default(),
+		base:    http.DefaultTransport,
+	}
+	c.chainTransport()
+	return c
+}
+
+// clone returns a copy of c, which shares its transport, circuit breaker,
+// endpoint pool and hedging budget.
+func (c *Client) clone() *Client {
+	clone := *c
+	httpClient := *c.Client
+	clone.Client = &httpClient
+	clone.headers = c.headers.Clone()
+	clone.roundTrippers = append([]transport.Middleware(nil), c.roundTrippers...)
+	return &clone
+}
+
+// WithRoundTrippers adds middlewares to the transport of the client, e.g.
+// transport.Logging or transport.Metrics. They run in order, after the
+// built-in request ID round-tripper, so they see each attempt of the retry
+// policy.
+func (c *Client) WithRoundTrippers(middlewares ...transport.Middleware) *Client {
+	c = c.clone()
+	c.roundTrippers = append(c.roundTrippers, middlewares...)
+	c.chainTransport()
+	return c
+}
+
+// WithTransport sets the transport sending the requests, wrapped by the
+// built-in and added round-trippers.
+func (c *Client) WithTransport(base http.RoundTripper) *Client {
+	c = c.clone()
+	c.base = base
+	c.Transport = nil
+	c.chainTransport()
+	return c
+}
+
+// chainTransport chains the round-trippers on the base transport. A transport
+// assigned to the client since the last chaining becomes the base.
+func (c *Client) chainTransport() {
+	if _, ok := c.Transport.(*chainedTransport); !ok && c.Transport != nil {
+		c.base = c.Transport
 	}
+	middlewares := append([]transport.Middleware{
+		transport.RequestID(),
+	}, c.roundTrippers...)
+	c.Transport = &chainedTransport{transport.Chain(c.base, middlewares...)}
 }
 
 func (c *Client) WithBaseURL(baseURL string) *Client {
+	c = c.clone()
 	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
 		baseURL = "http://" + baseURL
 	}
@@ -42,16 +129,46 @@ func (c *Client) WithBaseURL(baseURL string) *Client {
 }
 
 func (c *Client) WithTimeout(timeout time.Duration) *Client {
+	c = c.clone()
 	c.Timeout = timeout
 	return c
 }
 
+// WithHeaders sets a header sent with every request of the client.
 func (c *Client) WithHeaders(key string, value string) *Client {
-	c.headers[key] = value
+	c = c.clone()
+	c.headers.Set(key, value)
 	return c
 }
 
+// WithAuth authenticates each attempt with provider. If it's an
+// auth.Refresher, a 401 response refreshes the credentials and the request is
+// sent again once.
+func (c *Client) WithAuth(provider auth.Provider) *Client {
+	c = c.clone()
+	c.auth = provider
+	return c
+}
+
+// setHeaders sets the headers of the client, then the headers of the call
+// which take precedence.
+func (c *Client) setHeaders(req *http.Request, headers map[string]string) {
+	for key, values := range c.headers {
+		req.Header[key] = append([]string(nil), values...)
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+}
+
 func (c *Client) MakeJSONRequest(method, path string, headers map[string]string, input interface{}) (*http.Request, error) {
+	return c.MakeJSONRequestContext(context.Background(), method, path, headers, input)
+}
+
+// MakeJSONRequestContext is like MakeJSONRequest, but the request is bound to
+// ctx, so its cancellation and deadline apply to the call.
+func (c *Client) MakeJSONRequestContext(ctx context.Context, method, path string, headers map[string]string, input interface{}) (*http.Request, error) {
 	var body io.Reader
 	if input != nil {
 		var buf bytes.Buffer
@@ -61,25 +178,25 @@ func (c *Client) MakeJSONRequest(method, path string, headers map[string]string,
 		body = &buf
 	}
 
-	req, err := http.NewRequest(method, c.baseURL+path, body)
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
 	if err != nil {
 		return nil, err
 	}
 
-	for key, value := range headers {
-		req.Header.Set(key, value)
-	}
-
-	for key, value := range c.headers {
-		req.Header.Set(key, value)
-	}
+	c.setHeaders(req, headers)
 
 	req.Header.Set("Content-Type", "application/json")
 	return req, nil
 }
 
 func (c *Client) CommonDoWithJSON(method, path string, headers map[string]string, input, output interface{}) (int, error) {
-	req, err := c.MakeJSONRequest(method, path, headers, input)
+	return c.CommonDoWithJSONContext(context.Background(), method, path, headers, input, output)
+}
+
+// CommonDoWithJSONContext is like CommonDoWithJSON, but the request is bound
+// to ctx.
+func (c *Client) CommonDoWithJSONContext(ctx context.Context, method, path string, headers map[string]string, input, output interface{}) (int, error) {
+	req, err := c.MakeJSONRequestContext(ctx, method, path, headers, input)
 	if err != nil {
 		return -1, err
 	}
@@ -88,64 +205,205 @@ func (c *Client) CommonDoWithJSON(method, path string, headers map[string]string
 }
 
 func (c *Client) CommonDoWithForm(method, path string, headers map[string]string, input url.Values) (int, []byte, error) {
-	req, err := http.NewRequest(method, c.baseURL+path, strings.NewReader(input.Encode()))
+	return c.CommonDoWithFormContext(context.Background(), method, path, headers, input)
+}
+
+// CommonDoWithFormContext is like CommonDoWithForm, but the request is bound
+// to ctx.
+func (c *Client) CommonDoWithFormContext(ctx context.Context, method, path string, headers map[string]string, input url.Values) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, strings.NewReader(input.Encode()))
 	if err != nil {
 		return -1, nil, err
 	}
 
-	for key, value := range headers {
-		req.Header.Set(key, value)
-	}
-
-	for key, value := range c.headers {
-		req.Header.Set(key, value)
-	}
+	c.setHeaders(req, headers)
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	res, err := c.Client.Do(req)
+	res, err := c.do(req)
 	if err != nil {
 		return 0, nil, err
 	}
 	defer res.Body.Close()
 
 	statusCode := res.StatusCode
-	bs, err := io.ReadAll(res.Body)
-	if err != nil {
-		return statusCode, nil, errors.New("cannot read service error in response body")
+	if statusCode >= 300 {
+		return statusCode, nil, newResponseError(res, c.errorPayload)
 	}
-	bs = bytes.TrimSpace(bs)
 
-	if statusCode >= 300 {
-		return statusCode, nil, errors.New(string(bs))
+	bs, err := io.ReadAll(res.Body)
+	if err != nil {
+		return statusCode, nil, fmt.Errorf("cannot read service response body: %w", err)
 	}
 
-	return statusCode, bs, nil
+	return statusCode, bytes.TrimSpace(bs), nil
 }
 
+// CommonDoFromRequest sends req and decodes the response into output with the
+// codec of its Content-Type, JSON by default.
+// Use http.NewRequestWithContext to bind the call to a context.
 func (c *Client) CommonDoFromRequest(req *http.Request, output interface{}) (int, error) {
-	res, err := c.Client.Do(req)
+	res, err := c.do(req)
 	if err != nil {
 		return 0, err
 	}
 	defer res.Body.Close()
 	statusCode := res.StatusCode
 	if statusCode >= 300 {
-		var bs []byte
-		bs, err = io.ReadAll(res.Body)
+		return statusCode, newResponseError(res, c.errorPayload)
+	}
+
+	return statusCode, c.decodeResponse(res, output)
+}
+
+// do sends req and retries it according to the retry policy.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	policy := c.retryPolicy
+	attempts := 1
+	if policy.enabled() && isIdempotent(req) && canReplay(req) {
+		attempts = policy.MaxAttempts
+	}
+
+	logger := logging.FromContext(ctx)
+	tried := make(map[*endpoint]bool)
+	for attempt := 1; ; attempt++ {
+		res, err := c.sendHedged(req, attempt, tried)
+		if attempt == attempts || ctx.Err() != nil {
+			return res, err
+		}
+
+		var wait time.Duration
 		if err != nil {
-			return statusCode, fmt.Errorf("cannot read service error in response body: %w", err)
+			if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrNoEndpoints) || !policy.retryableError(err) {
+				return nil, err
+			}
+			wait = policy.backoff(attempt)
+		} else {
+			if !policy.retryableStatus(res.StatusCode) {
+				return res, nil
+			}
+			var ok bool
+			if wait, ok = retryAfter(res, time.Now()); !ok {
+				wait = policy.backoff(attempt)
+			} else if wait > policy.MaxBackoff {
+				return res, nil
+			}
+		}
+		if c.pool != nil && c.pool.hasUntried(tried, time.Now()) {
+			// Fails over to another endpoint right away.
+			wait = 0
+		}
+		if !fitsDeadline(ctx, wait) {
+			return res, err
+		}
+
+		next, rewindErr := rewind(req)
+		if rewindErr != nil {
+			return res, err
 		}
-		bs = bytes.TrimSpace(bs)
+		if res != nil {
+			discard(res)
+		}
+		logger.Infow("retrying outbound request",
+			"method", req.Method,
+			"url", req.URL.String(),
+			"attempt", attempt+1,
+			"wait", wait.String())
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+		req = next
+	}
+}
 
-		return statusCode, errors.New(string(bs))
+// sendToEndpoint sends req to an endpoint of the pool not tried yet, if any.
+func (c *Client) sendToEndpoint(req *http.Request, attempt int, tried map[*endpoint]bool) (*http.Response, error) {
+	routed, e, err := c.pickEndpoint(req, tried)
+	if err != nil {
+		return nil, err
+	}
+	return c.sendRouted(req, routed, e, attempt)
+}
+
+// pickEndpoint returns req routed to an endpoint of the pool not tried yet,
+// or req itself without pool.
+func (c *Client) pickEndpoint(req *http.Request, tried map[*endpoint]bool) (*http.Request, *endpoint, error) {
+	if c.pool == nil {
+		return req, nil, nil
+	}
+	e, err := c.pool.pick(tried, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	tried[e] = true
+	return e.route(req), e, nil
+}
+
+// sendRouted sends routed, the copy of req routed to e, and records the
+// result in the pool.
+func (c *Client) sendRouted(req, routed *http.Request, e *endpoint, attempt int) (*http.Response, error) {
+	res, err := c.sendAuthenticated(routed, attempt)
+	if e != nil {
+		c.pool.done(req, e, res, err, time.Now())
+	}
+	return res, err
+}
+
+// sendAuthenticated sends req with the credentials of the auth provider, and
+// sends it again once with refreshed credentials on 401.
+func (c *Client) sendAuthenticated(req *http.Request, attempt int) (*http.Response, error) {
+	if c.auth == nil {
+		return c.send(req, attempt)
+	}
+
+	authenticated, res, err := c.sendWithAuth(req, attempt)
+	refresher, ok := c.auth.(auth.Refresher)
+	if err != nil || res.StatusCode != http.StatusUnauthorized || !ok || !canReplay(req) {
+		return res, err
+	}
+
+	ctx := req.Context()
+	if refreshErr := refresher.Refresh(ctx, authenticated); refreshErr != nil {
+		logging.FromContext(ctx).Warnw("failed to refresh credentials", "err", refreshErr)
+		return res, nil
 	}
+	next, rewindErr := rewind(req)
+	if rewindErr != nil {
+		return res, nil
+	}
+	discard(res)
+	_, res, err = c.sendWithAuth(next, attempt)
+	return res, err
+}
+
+// sendWithAuth authenticates a copy of req, so the request of the caller is
+// left untouched, and sends it. It returns the authenticated copy.
+func (c *Client) sendWithAuth(req *http.Request, attempt int) (*http.Request, *http.Response, error) {
+	authenticated := req.Clone(req.Context())
+	if err := c.auth.Authenticate(authenticated); err != nil {
+		return nil, nil, fmt.Errorf("failed to authenticate request: %w", err)
+	}
+	res, err := c.send(authenticated, attempt)
+	return authenticated, res, err
+}
 
-	if output != nil {
-		if err = json.NewDecoder(res.Body).Decode(output); err != nil {
-			return statusCode, fmt.Errorf("failed to parse service response: %w", err)
+// send makes a single attempt of req through the circuit breaker.
+func (c *Client) send(req *http.Request, attempt int) (res *http.Response, err error) {
+	if c.breaker != nil {
+		generation, rejected := c.breaker.allow(req, time.Now())
+		if rejected != nil {
+			logging.FromContext(req.Context()).Warnw("outbound request rejected",
+				"method", req.Method,
+				"url", req.URL.String(),
+				"attempt", attempt,
+				"err", rejected)
+			return nil, rejected
 		}
+		defer func() {
+			c.breaker.done(req, generation, res, err, time.Now())
+		}()
 	}
 
-	return statusCode, nil
+	return c.Client.Do(req)
 }
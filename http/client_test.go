@@ -0,0 +1,862 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Genesic/mixednuts/http/auth"
+	"github.com/Genesic/mixednuts/http/codec"
+	"github.com/Genesic/mixednuts/http/middleware"
+	"github.com/Genesic/mixednuts/http/transport"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClient_RetryPolicy(t *testing.T) {
+	Convey("test client retry policy", t, func() {
+		var calls int32
+		var bodies []string
+		failures := int32(2)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bs, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(bs))
+			if atomic.AddInt32(&calls, 1) <= failures {
+				w.Header().Set("Retry-After", r.URL.Query().Get("retry-after"))
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{}`))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.URL).WithRetryPolicy(RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     5 * time.Millisecond,
+		})
+
+		Convey("retry idempotent requests and replay the body", func() {
+			code, err := client.CommonDoWithJSON(http.MethodPut, "/", nil, map[string]string{"a": "b"}, nil)
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, http.StatusOK)
+			So(calls, ShouldEqual, 3)
+			So(bodies, ShouldResemble, []string{"{\"a\":\"b\"}\n", "{\"a\":\"b\"}\n", "{\"a\":\"b\"}\n"})
+		})
+
+		Convey("return the last response after max attempts", func() {
+			failures = 5
+			code, err := client.CommonDoWithJSON(http.MethodGet, "/", nil, nil, nil)
+			So(err, ShouldNotBeNil)
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(calls, ShouldEqual, 3)
+		})
+
+		Convey("retry POST only with an idempotency key", func() {
+			code, _ := client.CommonDoWithJSON(http.MethodPost, "/", nil, nil, nil)
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(calls, ShouldEqual, 1)
+
+			code, _ = client.CommonDoWithJSON(http.MethodPost, "/", map[string]string{
+				middleware.IdempotencyKeyHeader: "key",
+			}, nil, nil)
+			So(code, ShouldEqual, http.StatusOK)
+			So(calls, ShouldEqual, 3)
+		})
+
+		Convey("stop when Retry-After exceeds the context deadline", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			client := client.WithRetryPolicy(RetryPolicy{MaxAttempts: 3, MaxBackoff: time.Minute})
+			code, err := client.CommonDoWithJSONContext(ctx, http.MethodGet, "/?retry-after=10", nil, nil, nil)
+			So(err, ShouldNotBeNil)
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(calls, ShouldEqual, 1)
+		})
+
+		Convey("stop when Retry-After exceeds the max backoff", func() {
+			code, err := client.CommonDoWithJSON(http.MethodGet, "/?retry-after=1", nil, nil, nil)
+			So(err, ShouldNotBeNil)
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(calls, ShouldEqual, 1)
+		})
+
+		Convey("retry attempts timing out", func() {
+			var slow int32 = 1
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&slow, -1) >= 0 {
+					time.Sleep(100 * time.Millisecond)
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			client := NewClient(server.URL).WithTimeout(20 * time.Millisecond).WithRetryPolicy(RetryPolicy{
+				MaxAttempts:    2,
+				InitialBackoff: time.Millisecond,
+			})
+			code, err := client.CommonDoWithJSON(http.MethodGet, "/", nil, nil, nil)
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("retry connection errors", func() {
+			var attempts int32
+			client := NewClient("http://127.0.0.1:1").WithRetryPolicy(RetryPolicy{
+				MaxAttempts:    2,
+				InitialBackoff: time.Millisecond,
+				RetryableError: func(error) bool {
+					atomic.AddInt32(&attempts, 1)
+					return true
+				},
+			})
+			_, err := client.CommonDoWithJSON(http.MethodGet, "/", nil, nil, nil)
+			So(err, ShouldNotBeNil)
+			So(attempts, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestIsConnectionError(t *testing.T) {
+	Convey("test connection errors", t, func() {
+		So(IsConnectionError(&net.OpError{Op: "dial", Err: errors.New("no route to host")}), ShouldBeTrue)
+		So(IsConnectionError(&net.DNSError{Err: "no such host", Name: "users"}), ShouldBeTrue)
+		So(IsConnectionError(&net.OpError{Op: "read", Err: syscall.ECONNRESET}), ShouldBeTrue)
+
+		So(IsConnectionError(io.EOF), ShouldBeFalse)
+		So(IsConnectionError(io.ErrUnexpectedEOF), ShouldBeFalse)
+		So(IsConnectionError(&net.OpError{Op: "remote error", Err: errors.New("tls: handshake failure")}), ShouldBeFalse)
+		So(IsConnectionError(&net.OpError{Op: "read", Err: errors.New("use of closed network connection")}), ShouldBeFalse)
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	Convey("test Retry-After parsing", t, func() {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		res := &http.Response{Header: http.Header{}}
+
+		res.Header.Set("Retry-After", "3")
+		d, ok := retryAfter(res, now)
+		So(ok, ShouldBeTrue)
+		So(d, ShouldEqual, 3*time.Second)
+
+		res.Header.Set("Retry-After", now.Add(time.Minute).Format(http.TimeFormat))
+		d, ok = retryAfter(res, now)
+		So(ok, ShouldBeTrue)
+		So(d, ShouldEqual, time.Minute)
+
+		res.Header.Set("Retry-After", "soon")
+		_, ok = retryAfter(res, now)
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	Convey("test client circuit breaker", t, func() {
+		var calls int32
+		var healthy atomic.Value
+		healthy.Store(false)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			if !healthy.Load().(bool) {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		reg := prometheus.NewRegistry()
+		host := strings.TrimPrefix(server.URL, "http://")
+		state := func() float64 {
+			return testutil.ToFloat64(newBreakerMetrics(reg).state.WithLabelValues(host))
+		}
+
+		Convey("trip on consecutive failures and recover through half-open", func() {
+			client := NewClient(server.URL).WithCircuitBreaker(CircuitBreakerConfig{
+				ConsecutiveFailures: 2,
+				OpenTimeout:         50 * time.Millisecond,
+				Registerer:          reg,
+			})
+			for i := 0; i < 2; i++ {
+				code, _ := client.CommonDoWithJSON(http.MethodGet, "/", nil, nil, nil)
+				So(code, ShouldEqual, http.StatusInternalServerError)
+			}
+			So(state(), ShouldEqual, float64(CircuitOpen))
+
+			_, err := client.CommonDoWithJSON(http.MethodGet, "/", nil, nil, nil)
+			So(errors.Is(err, ErrCircuitOpen), ShouldBeTrue)
+			var openErr *CircuitOpenError
+			So(errors.As(err, &openErr), ShouldBeTrue)
+			So(openErr.Host, ShouldEqual, host)
+			So(calls, ShouldEqual, 2)
+
+			time.Sleep(60 * time.Millisecond)
+			healthy.Store(true)
+			code, err := client.CommonDoWithJSON(http.MethodGet, "/", nil, nil, nil)
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, http.StatusOK)
+			So(state(), ShouldEqual, float64(CircuitClosed))
+			So(testutil.ToFloat64(newBreakerMetrics(reg).transitions.WithLabelValues(host, "closed", "open")), ShouldEqual, 1)
+		})
+
+		Convey("trip on the failure ratio and do not retry open circuits", func() {
+			client := NewClient(server.URL).
+				WithRetryPolicy(RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond}).
+				WithCircuitBreaker(CircuitBreakerConfig{
+					ConsecutiveFailures: -1,
+					FailureRatio:        0.5,
+					MinRequests:         4,
+					// Clamped to 1s, buckets of a shorter window would be empty.
+					Window:     time.Nanosecond,
+					Registerer: reg,
+				})
+			_, err := client.CommonDoWithJSON(http.MethodGet, "/", nil, nil, nil)
+			So(errors.Is(err, ErrCircuitOpen), ShouldBeTrue)
+			So(calls, ShouldEqual, 4)
+			So(state(), ShouldEqual, float64(CircuitOpen))
+		})
+	})
+}
+
+func TestClient_ResponseError(t *testing.T) {
+	Convey("test client response errors", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/json":
+				w.Header().Set("X-Reason", "missing")
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"reason":"missing"}` + "\n"))
+			case "/slow":
+				time.Sleep(100 * time.Millisecond)
+			default:
+				w.WriteHeader(http.StatusBadGateway)
+				_, _ = w.Write([]byte("upstream down"))
+			}
+		}))
+		defer server.Close()
+
+		type apiError struct {
+			Reason string `json:"reason"`
+		}
+		client := NewClient(server.URL).WithErrorPayload(func() interface{} { return &apiError{} })
+
+		Convey("expose status, headers, body and payload", func() {
+			code, err := client.CommonDoWithJSON(http.MethodGet, "/json", nil, nil, nil)
+			So(code, ShouldEqual, http.StatusNotFound)
+			var resErr *ResponseError
+			So(errors.As(err, &resErr), ShouldBeTrue)
+			So(resErr.Header.Get("X-Reason"), ShouldEqual, "missing")
+			So(resErr.Error(), ShouldEqual, `{"reason":"missing"}`)
+			So(resErr.Payload, ShouldResemble, &apiError{Reason: "missing"})
+			So(resErr.GetCode(), ShouldEqual, http.StatusNotFound)
+			So(resErr.GetMessage(), ShouldEqual, `{"reason":"missing"}`)
+			So(IsClientError(err), ShouldBeTrue)
+			So(IsServerError(err), ShouldBeFalse)
+		})
+
+		Convey("wrap plain bodies and form errors", func() {
+			_, _, err := client.CommonDoWithForm(http.MethodPost, "/plain", nil, nil)
+			var resErr *ResponseError
+			So(errors.As(err, &resErr), ShouldBeTrue)
+			So(resErr.Payload, ShouldBeNil)
+			So(resErr.GetMessage(), ShouldEqual, `{"message":"upstream down"}`)
+			So(IsServerError(err), ShouldBeTrue)
+		})
+
+		Convey("distinguish timeouts from connection errors", func() {
+			_, err := client.WithTimeout(10*time.Millisecond).CommonDoWithJSON(http.MethodGet, "/slow", nil, nil, nil)
+			So(IsTimeout(err), ShouldBeTrue)
+			So(IsConnectionError(err), ShouldBeFalse)
+
+			_, err = NewClient("127.0.0.1:1").CommonDoWithJSON(http.MethodGet, "/", nil, nil, nil)
+			So(IsTimeout(err), ShouldBeFalse)
+			So(IsConnectionError(err), ShouldBeTrue)
+		})
+	})
+}
+
+func TestClient_Generic(t *testing.T) {
+	Convey("test generic request helpers", t, func() {
+		type user struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		}
+		type apiError struct {
+			Reason string `json:"reason"`
+		}
+		type listUsers struct {
+			Status []string `query:"status"`
+			Cursor string   `query:"cursor,omitempty"`
+			Limit  *int     `query:"limit"`
+		}
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/users" && r.Method == http.MethodGet:
+				_ = json.NewEncoder(w).Encode([]user{{ID: 1, Name: r.URL.RawQuery + ";" + r.Header.Get("X-Tenant")}})
+			case r.URL.Path == "/users" && r.Method == http.MethodPost:
+				var u user
+				_ = json.NewDecoder(r.Body).Decode(&u)
+				u.ID = 2
+				w.WriteHeader(http.StatusCreated)
+				_ = json.NewEncoder(w).Encode(u)
+			case r.URL.Path == "/empty":
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"reason":"missing"}`))
+			}
+		}))
+		defer server.Close()
+		client := NewClient(server.URL).WithHeaders("X-Tenant", "default")
+		ctx := context.Background()
+
+		Convey("decode responses and encode struct queries", func() {
+			users, err := Get[[]user](ctx, client, "/users",
+				Query(listUsers{Status: []string{"active", "new"}}),
+				Header("X-Tenant", "acme"))
+			So(err, ShouldBeNil)
+			So(users, ShouldResemble, []user{{ID: 1, Name: "status=active&status=new;acme"}})
+
+			created, err := Post[user, user](ctx, client, "/users", user{Name: "bob"})
+			So(err, ShouldBeNil)
+			So(created, ShouldResemble, user{ID: 2, Name: "bob"})
+
+			_, err = Do[struct{}](ctx, client, http.MethodDelete, "/empty", nil)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("decode error payloads", func() {
+			_, err := Get[user](ctx, client, "/missing", ErrorPayload[apiError]())
+			var resErr *ResponseError
+			So(errors.As(err, &resErr), ShouldBeTrue)
+			So(resErr.StatusCode, ShouldEqual, http.StatusNotFound)
+			So(resErr.Payload, ShouldResemble, &apiError{Reason: "missing"})
+		})
+	})
+}
+
+func TestClient_Streaming(t *testing.T) {
+	Convey("test client streaming", t, func() {
+		content := strings.Repeat("0123456789", 10000)
+		var etag atomic.Value
+		etag.Store(`"v1"`)
+		var aborted int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/upload":
+				reader, err := r.MultipartReader()
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				var parts []string
+				for {
+					part, err := reader.NextPart()
+					if err == io.EOF {
+						break
+					}
+					bs, _ := io.ReadAll(part)
+					parts = append(parts, part.FormName()+":"+part.FileName()+":"+part.Header.Get("Content-Type")+":"+string(bs))
+				}
+				_ = json.NewEncoder(w).Encode(parts)
+			case "/export":
+				w.Header().Set("ETag", etag.Load().(string))
+				if r.Header.Get("Range") == "" && atomic.CompareAndSwapInt32(&aborted, 0, 1) {
+					// Fails the first download halfway.
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+					_, _ = w.Write([]byte(content[:len(content)/2]))
+					w.(http.Flusher).Flush()
+					panic(http.ErrAbortHandler)
+				}
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+			case "/wrong-range":
+				// Answers the start of the content whatever the range.
+				w.Header().Set("Content-Range", "bytes 0-9/"+strconv.Itoa(len(content)))
+				w.WriteHeader(http.StatusPartialContent)
+				_, _ = w.Write([]byte(content[:10]))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+		client := NewClient(server.URL)
+		ctx := context.Background()
+
+		Convey("stream multipart uploads", func() {
+			var parts []string
+			code, err := client.CommonDoWithMultipartContext(ctx, http.MethodPost, "/upload", nil, []Part{
+				Field("title", "report"),
+				File("file", "report.csv", strings.NewReader("a,b")),
+				{Name: "meta", FileName: "meta.json", ContentType: "application/json", Body: strings.NewReader(`{}`)},
+			}, &parts)
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, http.StatusOK)
+			So(parts, ShouldResemble, []string{
+				"title:::report",
+				"file:report.csv:application/octet-stream:a,b",
+				"meta:meta.json:application/json:{}",
+			})
+		})
+
+		Convey("stream response bodies", func() {
+			atomic.StoreInt32(&aborted, 1)
+			body, err := client.Stream(ctx, http.MethodGet, "/export", nil)
+			So(err, ShouldBeNil)
+			bs, _ := io.ReadAll(body)
+			_ = body.Close()
+			So(string(bs), ShouldEqual, content)
+
+			_, err = client.Stream(ctx, http.MethodGet, "/missing", nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("resume interrupted downloads with progress", func() {
+			var buf strings.Builder
+			var lastWritten, lastTotal int64
+			n, err := client.Download(ctx, "/export", &buf, DownloadOptions{
+				MaxResumes: 1,
+				Progress: func(written, total int64) {
+					lastWritten, lastTotal = written, total
+				},
+			})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, len(content))
+			So(buf.String(), ShouldEqual, content)
+			So(lastWritten, ShouldEqual, len(content))
+			So(lastTotal, ShouldEqual, len(content))
+		})
+
+		Convey("start from an offset and detect changes", func() {
+			atomic.StoreInt32(&aborted, 1)
+			var buf strings.Builder
+			n, err := client.Download(ctx, "/export", &buf, DownloadOptions{Offset: 10})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, len(content)-10)
+			So(buf.String(), ShouldEqual, content[10:])
+
+			atomic.StoreInt32(&aborted, 0)
+			etag.Store(`"v2"`)
+			d := &download{w: io.Discard, offset: 10, total: -1, validator: `"v1"`}
+			_, err = client.downloadFrom(ctx, "/export", d, nil)
+			So(err, ShouldEqual, ErrResourceChanged)
+		})
+
+		Convey("reject partial content of another range", func() {
+			var buf strings.Builder
+			n, err := client.Download(ctx, "/wrong-range", &buf, DownloadOptions{Offset: 10})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unexpected content range")
+			So(n, ShouldEqual, 0)
+			So(buf.Len(), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestClient_Auth(t *testing.T) {
+	Convey("test client auth providers", t, func() {
+		var token atomic.Value
+		token.Store("old")
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			bs, _ := io.ReadAll(r.Body)
+			if r.Header.Get("Authorization") != "Bearer new" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write(bs)
+		}))
+		defer server.Close()
+
+		provider := &refreshingProvider{token: &token}
+		client := NewClient(server.URL).WithAuth(provider)
+
+		Convey("refresh credentials and retry once on 401", func() {
+			var output map[string]string
+			code, err := client.CommonDoWithJSON(http.MethodPost, "/", nil, map[string]string{"a": "b"}, &output)
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, http.StatusOK)
+			So(output, ShouldResemble, map[string]string{"a": "b"})
+			So(calls, ShouldEqual, 2)
+			So(provider.refreshes, ShouldEqual, 1)
+		})
+
+		Convey("give up after one refresh", func() {
+			provider.fail = true
+			code, err := client.CommonDoWithJSON(http.MethodGet, "/", nil, nil, nil)
+			So(err, ShouldNotBeNil)
+			So(code, ShouldEqual, http.StatusUnauthorized)
+			So(calls, ShouldEqual, 2)
+		})
+	})
+}
+
+type refreshingProvider struct {
+	token     *atomic.Value
+	refreshes int
+	fail      bool
+}
+
+func (p *refreshingProvider) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+p.token.Load().(string))
+	return nil
+}
+
+func (p *refreshingProvider) Refresh(context.Context, *http.Request) error {
+	p.refreshes++
+	if !p.fail {
+		p.token.Store("new")
+	}
+	return nil
+}
+
+func TestClient_Codecs(t *testing.T) {
+	Convey("test client codecs", t, func() {
+		type order struct {
+			XMLName xml.Name `xml:"order"`
+			ID      int      `xml:"id"`
+			Item    string   `xml:"item"`
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/html" {
+				w.Header().Set("Content-Type", "text/html")
+				_, _ = w.Write([]byte("<html></html>"))
+				return
+			}
+			if r.URL.Path == "/untyped" {
+				// Disables the content type detection of net/http.
+				w.Header()["Content-Type"] = nil
+				_ = xml.NewEncoder(w).Encode(order{ID: 8})
+				return
+			}
+			var o order
+			_ = xml.NewDecoder(r.Body).Decode(&o)
+			o.ID = 7
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			w.Header().Set("X-Accept", r.Header.Get("Accept"))
+			_ = xml.NewEncoder(w).Encode(o)
+		}))
+		defer server.Close()
+		client := NewClient(server.URL)
+		ctx := context.Background()
+
+		Convey("encode and decode with the codec of the content type", func() {
+			req, err := client.MakeRequestContext(ctx, http.MethodPost, "/orders", codec.MIMEXML, nil, order{Item: "book"})
+			So(err, ShouldBeNil)
+			So(req.Header.Get("Accept"), ShouldEqual, codec.MIMEJSON)
+			var created order
+			code, err := client.CommonDoFromRequest(req, &created)
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, http.StatusOK)
+			So(created.ID, ShouldEqual, 7)
+			So(created.Item, ShouldEqual, "book")
+
+			created, err = Post[order, order](ctx, client, "/orders", order{Item: "pen"}, ContentType(codec.MIMEXML))
+			So(err, ShouldBeNil)
+			So(created.Item, ShouldEqual, "pen")
+		})
+
+		Convey("decode responses without content type with the accepted codec", func() {
+			var untyped order
+			_, err := client.CommonDoWithJSON(http.MethodGet, "/untyped", map[string]string{"Accept": codec.MIMEXML}, nil, &untyped)
+			So(err, ShouldBeNil)
+			So(untyped.ID, ShouldEqual, 8)
+
+			_, err = client.CommonDoWithJSON(http.MethodGet, "/untyped", nil, nil, &untyped)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("report unsupported content types", func() {
+			var output map[string]string
+			_, err := client.CommonDoWithJSON(http.MethodGet, "/html", nil, nil, &output)
+			var unsupported *codec.UnsupportedContentTypeError
+			So(errors.As(err, &unsupported), ShouldBeTrue)
+			So(unsupported.ContentType, ShouldEqual, "text/html")
+		})
+	})
+}
+
+func TestClient_EndpointPool(t *testing.T) {
+	Convey("test client endpoint pool", t, func() {
+		var served sync.Map
+		newServer := func(name string, status int) *httptest.Server {
+			return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				count, _ := served.LoadOrStore(name, new(int32))
+				atomic.AddInt32(count.(*int32), 1)
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(`"` + name + r.URL.Path + `"`))
+			}))
+		}
+		servedBy := func(name string) int32 {
+			count, ok := served.Load(name)
+			if !ok {
+				return 0
+			}
+			return atomic.LoadInt32(count.(*int32))
+		}
+		a, b, broken := newServer("a", http.StatusOK), newServer("b", http.StatusOK), newServer("broken", http.StatusBadGateway)
+		defer a.Close()
+		defer b.Close()
+		defer broken.Close()
+		ctx := context.Background()
+
+		Convey("balance round-robin, fail over and eject failing endpoints", func() {
+			pool, err := NewEndpointPool([]string{broken.URL, a.URL, b.URL}, PoolConfig{FailureThreshold: 2, Cooldown: time.Minute})
+			So(err, ShouldBeNil)
+			client := NewClient("http://service/api").
+				WithEndpointPool(pool).
+				WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second})
+
+			begin := time.Now()
+			for i := 0; i < 6; i++ {
+				name, err := Get[string](ctx, client, "/ping")
+				So(err, ShouldBeNil)
+				So(name, ShouldBeIn, []string{"a/api/ping", "b/api/ping"})
+			}
+			So(time.Since(begin), ShouldBeLessThan, time.Second)
+			So(servedBy("broken"), ShouldEqual, 2)
+			So(servedBy("a")+servedBy("b"), ShouldEqual, 6)
+		})
+
+		Convey("prefer the endpoint with the fewest requests in flight", func() {
+			pool, _ := NewEndpointPool([]string{a.URL, b.URL}, PoolConfig{Balancer: LeastInFlight})
+			client := NewClient("service").WithEndpointPool(pool)
+
+			first, err := client.Stream(ctx, http.MethodGet, "/", nil)
+			So(err, ShouldBeNil)
+			for i := 0; i < 3; i++ {
+				_, _ = client.CommonDoWithJSON(http.MethodGet, "/", nil, nil, nil)
+			}
+			_ = first.Close()
+			So(servedBy("a"), ShouldEqual, 1)
+			So(servedBy("b"), ShouldEqual, 3)
+		})
+
+		Convey("update endpoints at runtime", func() {
+			pool, _ := NewEndpointPool(nil, PoolConfig{})
+			client := NewClient("service").WithEndpointPool(pool)
+			_, err := client.CommonDoWithJSON(http.MethodGet, "/", nil, nil, nil)
+			So(errors.Is(err, ErrNoEndpoints), ShouldBeTrue)
+
+			watchCtx, cancel := context.WithCancel(ctx)
+			done := make(chan struct{})
+			go func() {
+				pool.Watch(watchCtx, time.Hour, StaticResolver(b.URL))
+				close(done)
+			}()
+			for len(pool.Endpoints()) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+			<-done
+			So(pool.Endpoints(), ShouldResemble, []string{b.URL})
+
+			lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+				return "", []*net.SRV{
+					{Target: "backup.local.", Port: 8081, Priority: 20},
+					{Target: "primary.local.", Port: 8080, Priority: 10},
+					{Target: "secondary.local.", Port: 8080, Priority: 10},
+				}, nil
+			}
+			defer func() { lookupSRV = net.DefaultResolver.LookupSRV }()
+			endpoints, err := SRVResolver("https", "api", "tcp", "service.local")(ctx)
+			So(err, ShouldBeNil)
+			So(endpoints, ShouldResemble, []string{"https://primary.local:8080", "https://secondary.local:8080"})
+		})
+	})
+}
+
+func TestClient_Derivation(t *testing.T) {
+	Convey("test client derivation and request options", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				time.Sleep(100 * time.Millisecond)
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"tenant": r.Header.Get("X-Tenant"),
+				"role":   r.Header.Get("X-Role"),
+				"auth":   r.Header.Get("Authorization"),
+				"type":   r.Header.Get("Content-Type"),
+				"accept": r.Header.Get("Accept"),
+			})
+		}))
+		defer server.Close()
+		base := NewClient(server.URL).WithHeaders("X-Tenant", "default")
+		ctx := context.Background()
+
+		Convey("derive clients without affecting the base", func() {
+			var wg sync.WaitGroup
+			derived := make([]*Client, 10)
+			for i := range derived {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					derived[i] = base.WithHeaders("X-Role", strconv.Itoa(i)).WithTimeout(time.Duration(i+1) * time.Second)
+				}(i)
+			}
+			wg.Wait()
+
+			headers, err := Get[map[string]string](ctx, derived[3], "/")
+			So(err, ShouldBeNil)
+			So(headers["role"], ShouldEqual, "3")
+			So(derived[3].Timeout, ShouldEqual, 4*time.Second)
+			So(derived[3].Transport, ShouldEqual, base.Transport)
+
+			headers, _ = Get[map[string]string](ctx, base, "/")
+			So(headers["role"], ShouldBeEmpty)
+			So(base.Timeout, ShouldEqual, 5*time.Second)
+		})
+
+		Convey("chain round-trippers on the transport of the client", func() {
+			var sent []string
+			record := func(name string) transport.Middleware {
+				return func(next http.RoundTripper) http.RoundTripper {
+					return transport.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+						sent = append(sent, name)
+						return next.RoundTrip(req)
+					})
+				}
+			}
+
+			client := NewClient(server.URL)
+			client.Transport = record("custom")(http.DefaultTransport)
+			_, err := Get[map[string]string](ctx, client.WithRoundTrippers(record("added")), "/")
+			So(err, ShouldBeNil)
+			So(sent, ShouldResemble, []string{"added", "custom"})
+
+			sent = nil
+			client = base.WithRoundTrippers(record("added")).WithTransport(record("custom")(http.DefaultTransport))
+			_, err = Get[map[string]string](ctx, client, "/")
+			So(err, ShouldBeNil)
+			So(sent, ShouldResemble, []string{"added", "custom"})
+		})
+
+		Convey("let call headers override client headers", func() {
+			var headers map[string]string
+			_, err := base.CommonDoWithJSON(http.MethodGet, "/", map[string]string{"X-Tenant": "acme"}, nil, &headers)
+			So(err, ShouldBeNil)
+			So(headers["tenant"], ShouldEqual, "acme")
+
+			headers, _ = Get[map[string]string](ctx, base, "/", Header("X-Tenant", "option"))
+			So(headers["tenant"], ShouldEqual, "option")
+		})
+
+		Convey("keep the derived headers over the Header option", func() {
+			headers, err := Post[map[string]string, map[string]string](ctx, base, "/", nil,
+				Header("Content-Type", "text/plain"), Header("Accept", codec.MIMEXML))
+			So(err, ShouldBeNil)
+			So(headers["type"], ShouldEqual, codec.MIMEJSON)
+			So(headers["accept"], ShouldEqual, codec.MIMEXML)
+		})
+
+		Convey("apply per-request timeout and auth", func() {
+			_, err := Get[map[string]string](ctx, base, "/slow", Timeout(10*time.Millisecond))
+			So(IsTimeout(err), ShouldBeTrue)
+
+			headers, err := Get[map[string]string](ctx, base, "/", Auth(auth.Bearer("token")))
+			So(err, ShouldBeNil)
+			So(headers["auth"], ShouldEqual, "Bearer token")
+		})
+	})
+}
+
+func TestClient_Hedging(t *testing.T) {
+	Convey("test client hedged requests", t, func() {
+		var calls int32
+		canceled := make(chan struct{}, 10)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1)%2 == 1 {
+				// Every other request is stuck on a slow replica.
+				select {
+				case <-r.Context().Done():
+					canceled <- struct{}{}
+					return
+				case <-time.After(time.Second):
+				}
+			}
+			_, _ = w.Write([]byte(`"` + r.Method + `"`))
+		}))
+		defer server.Close()
+		reg := prometheus.NewRegistry()
+		ctx := context.Background()
+		host := strings.TrimPrefix(server.URL, "http://")
+		hedges := func(outcome string) float64 {
+			return testutil.ToFloat64(newHedger(HedgingPolicy{Registerer: reg}.withDefaults()).hedges.WithLabelValues(host, outcome))
+		}
+
+		Convey("use the hedge and cancel the slow request", func() {
+			client := NewClient(server.URL).WithHedging(HedgingPolicy{Delay: 20 * time.Millisecond, Registerer: reg})
+			begin := time.Now()
+			method, err := Get[string](ctx, client, "/")
+			So(err, ShouldBeNil)
+			So(method, ShouldEqual, http.MethodGet)
+			So(time.Since(begin), ShouldBeLessThan, 500*time.Millisecond)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+			select {
+			case <-canceled:
+			case <-time.After(time.Second):
+				So("slow request not canceled", ShouldBeEmpty)
+			}
+			So(hedges("won"), ShouldEqual, 1)
+		})
+
+		Convey("not hedge non-idempotent requests", func() {
+			client := NewClient(server.URL).WithHedging(HedgingPolicy{Delay: 20 * time.Millisecond, Registerer: reg})
+			method, err := Post[map[string]string, string](ctx, client, "/", nil)
+			So(err, ShouldBeNil)
+			So(method, ShouldEqual, http.MethodPost)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+
+			// Even with an idempotency key, which allows retries.
+			atomic.StoreInt32(&calls, 0)
+			method, err = Post[map[string]string, string](ctx, client, "/", nil,
+				Header(middleware.IdempotencyKeyHeader, "key"))
+			So(err, ShouldBeNil)
+			So(method, ShouldEqual, http.MethodPost)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		})
+
+		Convey("bound hedges by the budget", func() {
+			client := NewClient(server.URL).WithHedging(HedgingPolicy{
+				Delay:       20 * time.Millisecond,
+				BudgetRatio: 0.01,
+				BudgetBurst: 1,
+				Registerer:  reg,
+			})
+			_, err := Get[string](ctx, client, "/")
+			So(err, ShouldBeNil)
+			atomic.StoreInt32(&calls, 0)
+			begin := time.Now()
+			_, err = Get[string](ctx, client, "/")
+			So(err, ShouldBeNil)
+			So(time.Since(begin), ShouldBeGreaterThanOrEqualTo, time.Second)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+			So(hedges("throttled"), ShouldEqual, 1)
+		})
+
+		Convey("delay hedges by a percentile of the observed latencies", func() {
+			h := newHedger(HedgingPolicy{Percentile: 0.9, Registerer: reg}.withDefaults())
+			for i := 1; i <= 100; i++ {
+				h.observe(time.Duration(i) * time.Millisecond)
+			}
+			So(h.currentDelay(), ShouldEqual, 91*time.Millisecond)
+		})
+	})
+}
@@ -0,0 +1,102 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/Genesic/mixednuts/http/codec"
+)
+
+// WithCodecs sets the registry used to encode requests and decode responses.
+// Defaults to codec.Default().
+func (c *Client) WithCodecs(registry *codec.Registry) *Client {
+	c = c.clone()
+	c.codecs = registry
+	return c
+}
+
+// MakeRequestContext is like MakeJSONRequestContext, but input is encoded with
+// the codec of contentType. The Accept header defaults to JSON, whatever the
+// content type of the body; set it in headers to accept other types, e.g. with
+// codec.Registry.Accept.
+func (c *Client) MakeRequestContext(ctx context.Context, method, path, contentType string, headers map[string]string, input interface{}) (*http.Request, error) {
+	return c.makeRequest(ctx, method, path, contentType, headers, nil, input)
+}
+
+// makeRequest is MakeRequestContext with the headers of the Header request
+// option, set after headers but before the derived Content-Type and the
+// default Accept.
+func (c *Client) makeRequest(ctx context.Context, method, path, contentType string, headers map[string]string, optionHeader http.Header, input interface{}) (*http.Request, error) {
+	cdc, err := c.codecs.Lookup(contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	if input != nil {
+		bs, err := cdc.Marshal(input)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(bs)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	c.setHeaders(req, headers)
+	for key, values := range optionHeader {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", codec.MIMEJSON)
+	}
+	return req, nil
+}
+
+// decodeResponse decodes the body of res into output with the codec of its
+// Content-Type. Responses without Content-Type are decoded with the codec
+// negotiated from the Accept header of the request, JSON by default, and
+// text/plain responses, which net/http servers detect for JSON written without
+// header, are decoded as JSON. An empty body leaves output untouched.
+func (c *Client) decodeResponse(res *http.Response, output interface{}) error {
+	if output == nil {
+		return nil
+	}
+
+	cdc, err := c.responseCodec(res)
+	if err != nil {
+		return fmt.Errorf("cannot decode service response: %w", err)
+	}
+
+	bs, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("cannot read service response body: %w", err)
+	}
+	if len(bytes.TrimSpace(bs)) == 0 {
+		return nil
+	}
+	if err := cdc.Unmarshal(bs, output); err != nil {
+		return fmt.Errorf("failed to parse %s service response: %w", cdc.ContentType(), err)
+	}
+	return nil
+}
+
+// responseCodec returns the codec decoding the body of res.
+func (c *Client) responseCodec(res *http.Response) (codec.Codec, error) {
+	contentType := res.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "" && res.Request != nil:
+		return c.codecs.Negotiate(res.Request.Header.Get("Accept"))
+	case mediaType == "" || mediaType == "text/plain":
+		return c.codecs.Lookup(codec.MIMEJSON)
+	}
+	return c.codecs.Lookup(contentType)
+}
@@ -0,0 +1,176 @@
+// Package codec encodes and decodes http bodies by Content-Type.
+package codec
+
+import (
+	"fmt"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	MIMEJSON      = "application/json"
+	MIMEXML       = "application/xml"
+	MIMEForm      = "application/x-www-form-urlencoded"
+	MIMEProtobuf  = "application/x-protobuf"
+	MIMEProtoJSON = "application/protojson"
+)
+
+// Codec marshals and unmarshals the bodies of a media type.
+type Codec interface {
+	// ContentType is the media type of the codec, without parameters.
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// UnsupportedContentTypeError is returned for a media type without codec.
+type UnsupportedContentTypeError struct {
+	ContentType string
+}
+
+func (e *UnsupportedContentTypeError) Error() string {
+	return fmt.Sprintf("unsupported content type %q", e.ContentType)
+}
+
+// Registry looks up codecs by media type. It's safe for concurrent use.
+type Registry struct {
+	mu     sync.RWMutex
+	codecs map[string]Codec
+	// order is the registration order, used as the preference order of Accept.
+	order []string
+}
+
+// NewRegistry returns a registry with codecs. Use Default for the built-in
+// codecs.
+func NewRegistry(codecs ...Codec) *Registry {
+	r := &Registry{codecs: make(map[string]Codec)}
+	for _, c := range codecs {
+		r.Register(c)
+	}
+	return r
+}
+
+var defaultRegistry = NewRegistry(JSON{}, XML{}, Form{}, Protobuf{}, ProtoJSON{})
+
+// Default returns the registry of the built-in codecs: JSON, XML, form,
+// protobuf and protojson.
+func Default() *Registry {
+	return defaultRegistry
+}
+
+// Register adds a codec to the default registry, shared by every client using
+// it. It's meant to be called during initialization, e.g. in an init function;
+// prefer a registry of your own set with Client.WithCodecs to change the codecs
+// of a single client.
+func Register(c Codec) {
+	defaultRegistry.Register(c)
+}
+
+// Register adds c, replacing the codec of the same media type.
+func (r *Registry) Register(c Codec) {
+	mediaType := strings.ToLower(c.ContentType())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.codecs[mediaType]; !ok {
+		r.order = append(r.order, mediaType)
+	}
+	r.codecs[mediaType] = c
+}
+
+// Lookup returns the codec of a Content-Type header value. Parameters such as
+// charset are ignored, and structured syntax suffixes fall back to their base
+// codec, e.g. application/problem+json uses the JSON codec.
+func (r *Registry) Lookup(contentType string) (Codec, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, &UnsupportedContentTypeError{ContentType: contentType}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if c, ok := r.codecs[mediaType]; ok {
+		return c, nil
+	}
+	switch {
+	case strings.HasSuffix(mediaType, "+json"):
+		if c, ok := r.codecs[MIMEJSON]; ok {
+			return c, nil
+		}
+	case strings.HasSuffix(mediaType, "+xml"), mediaType == "text/xml":
+		if c, ok := r.codecs[MIMEXML]; ok {
+			return c, nil
+		}
+	}
+	return nil, &UnsupportedContentTypeError{ContentType: mediaType}
+}
+
+// Accept returns an Accept header value listing the media types of the
+// registry, preferring preferred, e.g. "application/xml, application/json;q=0.9".
+// Clients only accept JSON by default; use it to accept every registered type.
+func (r *Registry) Accept(preferred string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	types := []string{}
+	if preferred != "" {
+		types = append(types, preferred)
+	}
+	for _, mediaType := range r.order {
+		if mediaType != preferred {
+			types = append(types, mediaType)
+		}
+	}
+	for i := 1; i < len(types); i++ {
+		q := 1 - float64(i)/10
+		if q < 0.1 {
+			q = 0.1
+		}
+		types[i] += ";q=" + strconv.FormatFloat(q, 'f', -1, 64)
+	}
+	return strings.Join(types, ", ")
+}
+
+// Negotiate returns the codec of the registry preferred by an Accept header
+// value, or the JSON codec if accept is empty or allows any type.
+func (r *Registry) Negotiate(accept string) (Codec, error) {
+	if strings.TrimSpace(accept) == "" {
+		return r.Lookup(MIMEJSON)
+	}
+
+	type candidate struct {
+		mediaType string
+		q         float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{mediaType: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+
+	for _, c := range candidates {
+		if c.mediaType == "*/*" || c.mediaType == "application/*" {
+			return r.Lookup(MIMEJSON)
+		}
+		if codec, err := r.Lookup(c.mediaType); err == nil {
+			return codec, nil
+		}
+	}
+	return nil, &UnsupportedContentTypeError{ContentType: accept}
+}
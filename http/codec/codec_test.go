@@ -0,0 +1,96 @@
+package codec
+
+import (
+	"net/url"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRegistry(t *testing.T) {
+	Convey("test codec registry", t, func() {
+		registry := Default()
+
+		Convey("look up codecs by content type", func() {
+			c, err := registry.Lookup("application/json; charset=utf-8")
+			So(err, ShouldBeNil)
+			So(c.ContentType(), ShouldEqual, MIMEJSON)
+
+			c, _ = registry.Lookup("application/problem+json")
+			So(c.ContentType(), ShouldEqual, MIMEJSON)
+			c, _ = registry.Lookup("text/xml")
+			So(c.ContentType(), ShouldEqual, MIMEXML)
+
+			_, err = registry.Lookup("text/html")
+			So(err, ShouldResemble, &UnsupportedContentTypeError{ContentType: "text/html"})
+		})
+
+		Convey("negotiate with Accept", func() {
+			So(registry.Accept(MIMEXML), ShouldStartWith, "application/xml, application/json;q=0.9, application/x-www-form-urlencoded;q=0.8")
+
+			c, err := registry.Negotiate("text/html, application/xml;q=0.5, application/x-protobuf;q=0.8")
+			So(err, ShouldBeNil)
+			So(c.ContentType(), ShouldEqual, MIMEProtobuf)
+			c, _ = registry.Negotiate("*/*")
+			So(c.ContentType(), ShouldEqual, MIMEJSON)
+			_, err = registry.Negotiate("text/html")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("register custom codecs", func() {
+			custom := NewRegistry(JSON{})
+			custom.Register(plainText{})
+			c, err := custom.Lookup("text/plain")
+			So(err, ShouldBeNil)
+			So(c, ShouldResemble, plainText{})
+			_, err = custom.Lookup(MIMEXML)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestCodecs(t *testing.T) {
+	Convey("test built-in codecs", t, func() {
+		Convey("form", func() {
+			type login struct {
+				User     string `form:"user"`
+				Remember bool   `form:"remember"`
+			}
+			bs, err := Form{}.Marshal(login{User: "bob", Remember: true})
+			So(err, ShouldBeNil)
+			So(string(bs), ShouldEqual, "remember=true&user=bob")
+
+			var decoded login
+			So(Form{}.Unmarshal(bs, &decoded), ShouldBeNil)
+			So(decoded, ShouldResemble, login{User: "bob", Remember: true})
+
+			var values url.Values
+			So(Form{}.Unmarshal(bs, &values), ShouldBeNil)
+			So(values.Get("user"), ShouldEqual, "bob")
+		})
+
+		Convey("protobuf and protojson", func() {
+			for _, c := range []Codec{Protobuf{}, ProtoJSON{}} {
+				bs, err := c.Marshal(wrapperspb.String("hello"))
+				So(err, ShouldBeNil)
+				decoded := &wrapperspb.StringValue{}
+				So(c.Unmarshal(bs, decoded), ShouldBeNil)
+				So(decoded.GetValue(), ShouldEqual, "hello")
+
+				_, err = c.Marshal(struct{}{})
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+type plainText struct{}
+
+func (plainText) ContentType() string                   { return "text/plain" }
+func (plainText) Marshal(v interface{}) ([]byte, error) { return []byte(v.(string)), nil }
+func (plainText) Unmarshal(data []byte, v interface{}) error {
+	*v.(*string) = string(data)
+	return nil
+}
@@ -0,0 +1,125 @@
+package codec
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+
+	"github.com/Genesic/mixednuts/http/binding"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// JSON is the codec of application/json.
+type JSON struct{}
+
+func (JSON) ContentType() string { return MIMEJSON }
+
+func (JSON) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (JSON) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// XML is the codec of application/xml.
+type XML struct{}
+
+func (XML) ContentType() string { return MIMEXML }
+
+func (XML) Marshal(v interface{}) ([]byte, error) { return xml.Marshal(v) }
+
+func (XML) Unmarshal(data []byte, v interface{}) error { return xml.Unmarshal(data, v) }
+
+// Form is the codec of application/x-www-form-urlencoded. It encodes
+// url.Values, map[string]string and structs with `form` tags, and decodes into
+// the same types.
+type Form struct{}
+
+func (Form) ContentType() string { return MIMEForm }
+
+func (Form) Marshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case url.Values:
+		return []byte(v.Encode()), nil
+	case map[string]string:
+		values := url.Values{}
+		for key, value := range v {
+			values.Set(key, value)
+		}
+		return []byte(values.Encode()), nil
+	}
+	values, err := EncodeForm(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(values.Encode()), nil
+}
+
+func (Form) Unmarshal(data []byte, v interface{}) error {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case *url.Values:
+		*v = values
+		return nil
+	case *map[string]string:
+		*v = make(map[string]string, len(values))
+		for key := range values {
+			(*v)[key] = values.Get(key)
+		}
+		return nil
+	}
+	return binding.DecodeForm(values, v)
+}
+
+// Protobuf is the codec of application/x-protobuf, for proto.Message values.
+type Protobuf struct{}
+
+func (Protobuf) ContentType() string { return MIMEProtobuf }
+
+func (Protobuf) Marshal(v interface{}) ([]byte, error) {
+	msg, err := protoMessage(v)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(msg)
+}
+
+func (Protobuf) Unmarshal(data []byte, v interface{}) error {
+	msg, err := protoMessage(v)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+// ProtoJSON is the codec of application/protojson, the canonical JSON mapping
+// of proto.Message values.
+type ProtoJSON struct{}
+
+func (ProtoJSON) ContentType() string { return MIMEProtoJSON }
+
+func (ProtoJSON) Marshal(v interface{}) ([]byte, error) {
+	msg, err := protoMessage(v)
+	if err != nil {
+		return nil, err
+	}
+	return protojson.Marshal(msg)
+}
+
+func (ProtoJSON) Unmarshal(data []byte, v interface{}) error {
+	msg, err := protoMessage(v)
+	if err != nil {
+		return err
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, msg)
+}
+
+func protoMessage(v interface{}) (proto.Message, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("codec: %T is not a proto.Message", v)
+	}
+	return msg, nil
+}
@@ -0,0 +1,191 @@
+package codec
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tagForm  = "form"
+	tagQuery = "query"
+)
+
+var (
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+// EncodeQuery is the inverse of the query binding of the server: it encodes
+// the fields of the struct v tagged with `query` into url.Values, so clients
+// can share the request structs of the server. Slices are encoded as repeated
+// parameters, nil pointers are skipped, and so are zero values with the
+// omitempty option:
+//
+//	type listUsers struct {
+//		Status []string `query:"status"`
+//		Cursor string   `query:"cursor,omitempty"`
+//	}
+func EncodeQuery(v interface{}) (url.Values, error) {
+	return encodeValues(v, tagQuery)
+}
+
+// EncodeForm is like EncodeQuery for the fields tagged with `form`.
+func EncodeForm(v interface{}) (url.Values, error) {
+	return encodeValues(v, tagForm)
+}
+
+func encodeValues(v interface{}, tag string) (url.Values, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return url.Values{}, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("codec: expects a struct to encode, got %s", rv.Type())
+	}
+
+	values := url.Values{}
+	if err := encodeStruct(rv, tag, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func encodeStruct(v reflect.Value, tag string, values url.Values) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+
+		name, ok := tagName(sf, tag)
+		if !ok {
+			if isNestedStruct(sf.Type) {
+				if fv.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						continue
+					}
+					fv = fv.Elem()
+				}
+				if err := encodeStruct(fv, tag, values); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if fv.IsZero() && hasOption(sf.Tag.Get(tag), "omitempty") {
+			continue
+		}
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			for j := 0; j < fv.Len(); j++ {
+				s, ok, err := formatValue(fv.Index(j))
+				if err != nil {
+					return fmt.Errorf("codec: field %s: %w", name, err)
+				}
+				if ok {
+					values.Add(name, s)
+				}
+			}
+			continue
+		}
+		s, ok, err := formatValue(fv)
+		if err != nil {
+			return fmt.Errorf("codec: field %s: %w", name, err)
+		}
+		if ok {
+			values.Add(name, s)
+		}
+	}
+	return nil
+}
+
+// formatValue formats a field as the binding package parses it. It returns
+// false for nil pointers.
+func formatValue(fv reflect.Value) (string, bool, error) {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return "", false, nil
+		}
+		return formatValue(fv.Elem())
+	}
+
+	if fv.Type().Implements(textMarshalerType) {
+		bs, err := fv.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(bs), true, err
+	}
+	if fv.CanAddr() && fv.Addr().Type().Implements(textMarshalerType) {
+		bs, err := fv.Addr().Interface().(encoding.TextMarshaler).MarshalText()
+		return string(bs), true, err
+	}
+
+	if fv.Type() == durationType {
+		return time.Duration(fv.Int()).String(), true, nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String(), true, nil
+	case reflect.Bool:
+		return strconv.FormatBool(fv.Bool()), true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fv.Uint(), 10), true, nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'f', -1, fv.Type().Bits()), true, nil
+	case reflect.Slice:
+		// []byte
+		return string(fv.Bytes()), true, nil
+	}
+	return "", false, fmt.Errorf("unsupported field type %s", fv.Type())
+}
+
+func hasOption(value, option string) bool {
+	for _, opt := range strings.Split(value, ",")[1:] {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// isNestedStruct reports whether t is a struct whose fields are encoded, as
+// opposed to a value such as time.Time.
+func isNestedStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return false
+	}
+	return !reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// tagName returns the name of the field in the given tag, ignoring options
+// such as ",omitempty".
+func tagName(sf reflect.StructField, tag string) (string, bool) {
+	value, ok := sf.Tag.Lookup(tag)
+	if !ok {
+		return "", false
+	}
+	name := strings.Split(value, ",")[0]
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = sf.Name
+	}
+	return name, true
+}
@@ -0,0 +1,57 @@
+package codec
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncodeQuery(t *testing.T) {
+	Convey("test query encoding", t, func() {
+		type Page struct {
+			Cursor string `query:"cursor,omitempty"`
+			Limit  int    `query:"limit"`
+		}
+		type search struct {
+			Page
+			Tags    []string      `query:"tag"`
+			Since   time.Time     `query:"since"`
+			Timeout time.Duration `query:"timeout"`
+			Exact   *bool         `query:"exact"`
+			Ignored string        `query:"-"`
+		}
+
+		values, err := EncodeQuery(&search{
+			Page:    Page{Limit: 10},
+			Tags:    []string{"a", "b"},
+			Since:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			Timeout: time.Second,
+			Ignored: "x",
+		})
+		So(err, ShouldBeNil)
+		So(values, ShouldResemble, url.Values{
+			"limit":   {"10"},
+			"tag":     {"a", "b"},
+			"since":   {"2024-01-02T03:04:05Z"},
+			"timeout": {"1s"},
+		})
+
+		Convey("round trip forms through the binding", func() {
+			type order struct {
+				Items   []string      `form:"item"`
+				Timeout time.Duration `form:"timeout"`
+			}
+			bs, err := Form{}.Marshal(order{Items: []string{"a", "b"}, Timeout: time.Second})
+			So(err, ShouldBeNil)
+			var decoded order
+			So(Form{}.Unmarshal(bs, &decoded), ShouldBeNil)
+			So(decoded.Items, ShouldResemble, []string{"a", "b"})
+			So(decoded.Timeout, ShouldEqual, time.Second)
+		})
+
+		_, err = EncodeQuery("not a struct")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,156 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Genesic/mixednuts/http/auth"
+	"github.com/Genesic/mixednuts/http/codec"
+)
+
+// RequestOption customizes a single request made with Get, Post or Do. The
+// CommonDo* methods of Client don't take options; they have a headers argument
+// and a Context variant instead.
+type RequestOption func(*requestConfig) error
+
+type requestConfig struct {
+	contentType  string
+	header       http.Header
+	query        url.Values
+	timeout      time.Duration
+	auth         auth.Provider
+	errorPayload func() interface{}
+}
+
+// Header sets a header on the request. It takes precedence over the headers
+// of the Client, but not over the headers derived from the call such as the
+// Content-Type of the body; use ContentType to change it. See Client for the
+// precedence of headers.
+func Header(key, value string) RequestOption {
+	return func(cfg *requestConfig) error {
+		cfg.header.Set(key, value)
+		return nil
+	}
+}
+
+// Query adds query parameters to the request. v is either url.Values or a
+// struct encoded with codec.EncodeQuery.
+func Query(v interface{}) RequestOption {
+	return func(cfg *requestConfig) error {
+		values, ok := v.(url.Values)
+		if !ok {
+			var err error
+			if values, err = codec.EncodeQuery(v); err != nil {
+				return err
+			}
+		}
+		for key, vs := range values {
+			cfg.query[key] = append(cfg.query[key], vs...)
+		}
+		return nil
+	}
+}
+
+// ErrorPayload decodes the body of a non-2xx response into a new E, available
+// as the ResponseError.Payload of type *E. It overrides
+// Client.WithErrorPayload for the request.
+func ErrorPayload[E any]() RequestOption {
+	return func(cfg *requestConfig) error {
+		cfg.errorPayload = func() interface{} { return new(E) }
+		return nil
+	}
+}
+
+// Timeout bounds the whole call, including the retries and reading the
+// response, unlike the Client timeout which applies to each attempt.
+func Timeout(timeout time.Duration) RequestOption {
+	return func(cfg *requestConfig) error {
+		cfg.timeout = timeout
+		return nil
+	}
+}
+
+// Auth authenticates the request with provider instead of the auth provider
+// of the Client.
+func Auth(provider auth.Provider) RequestOption {
+	return func(cfg *requestConfig) error {
+		cfg.auth = provider
+		return nil
+	}
+}
+
+// ContentType encodes the request body with the codec of contentType instead
+// of JSON.
+func ContentType(contentType string) RequestOption {
+	return func(cfg *requestConfig) error {
+		cfg.contentType = contentType
+		return nil
+	}
+}
+
+// Get sends a GET request to path and decodes the JSON response into a T.
+//
+//	user, err := http.Get[User](ctx, client, "/users/1")
+func Get[T any](ctx context.Context, c *Client, path string, opts ...RequestOption) (T, error) {
+	return Do[T](ctx, c, http.MethodGet, path, nil, opts...)
+}
+
+// Post sends body as JSON to path and decodes the JSON response into a Resp.
+func Post[Req, Resp any](ctx context.Context, c *Client, path string, body Req, opts ...RequestOption) (Resp, error) {
+	return Do[Resp](ctx, c, http.MethodPost, path, body, opts...)
+}
+
+// Do sends a request with body encoded as JSON, or with the codec of the
+// ContentType option, unless it's nil, to the base URL and with the headers of
+// c. The response is decoded into a T with the codec of its Content-Type; an
+// empty body results in the zero value. Non-2xx responses result in a
+// *ResponseError.
+func Do[T any](ctx context.Context, c *Client, method, path string, body interface{}, opts ...RequestOption) (T, error) {
+	var output T
+	cfg := requestConfig{
+		contentType:  codec.MIMEJSON,
+		header:       http.Header{},
+		query:        url.Values{},
+		errorPayload: c.errorPayload,
+	}
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return output, err
+		}
+	}
+
+	if cfg.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.timeout)
+		defer cancel()
+	}
+	if cfg.auth != nil {
+		c = c.WithAuth(cfg.auth)
+	}
+
+	if len(cfg.query) > 0 {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		path += sep + cfg.query.Encode()
+	}
+	req, err := c.makeRequest(ctx, method, path, cfg.contentType, nil, cfg.header, body)
+	if err != nil {
+		return output, err
+	}
+
+	res, err := c.do(req)
+	if err != nil {
+		return output, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return output, newResponseError(res, cfg.errorPayload)
+	}
+	return output, c.decodeResponse(res, &output)
+}
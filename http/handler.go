@@ -0,0 +1,171 @@
+package http
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/Genesic/mixednuts/errors"
+	"github.com/Genesic/mixednuts/http/middleware"
+	"github.com/Genesic/mixednuts/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// HandlerFunc is an http handler which returns an error instead of writing it
+// to the response. Returned errors are translated into responses by
+// HandleError, so controllers can register it directly on mux routes:
+//
+//	router.Methods(http.MethodGet).Path("/users/{id}").Handler(HandlerFunc(c.getUser))
+type HandlerFunc func(http.ResponseWriter, *http.Request) error
+
+func (f HandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f(w, r); err != nil {
+		HandleError(w, r, err)
+	}
+}
+
+// ErrorMapper converts an error returned by a HandlerFunc into an
+// errors.HttpError. Returning nil falls back to the default translation.
+type ErrorMapper func(error) errors.HttpError
+
+var errorMapper atomic.Value // ErrorMapper
+
+// SetErrorMapper installs a hook used by HandleError before the default
+// translation, for every handler of the process. It should be called during
+// initialization, before serving.
+func SetErrorMapper(mapper ErrorMapper) {
+	errorMapper.Store(mapper)
+}
+
+type errorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// HandleError writes err to w and attaches it to the ResponseWriter for
+// logging. errors.HttpError writes its message as the JSON body with its code,
+// or in the {code,message} envelope if the message isn't JSON,
+// errors.GrpcError and gRPC status errors are mapped to the corresponding
+// http status, a body exceeding http.MaxBytesReader results in 413, and any
+// other error results in 500.
+func HandleError(w http.ResponseWriter, r *http.Request, err error) {
+	if rw, ok := w.(*middleware.ResponseWriter); ok {
+		rw.WriteError(err)
+	}
+
+	if mapper, _ := errorMapper.Load().(ErrorMapper); mapper != nil {
+		if httpErr := mapper(err); httpErr != nil {
+			writeHttpError(w, httpErr)
+			return
+		}
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if stderrors.As(err, &maxBytesErr) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, errorResponse{
+			Code:    int(codes.ResourceExhausted),
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var httpErr errors.HttpError
+	if stderrors.As(err, &httpErr) {
+		writeHttpError(w, httpErr)
+		return
+	}
+	var grpcErr errors.GrpcError
+	if stderrors.As(err, &grpcErr) {
+		writeJSONError(w, HTTPStatusFromCode(grpcErr.GetCode()), errorResponse{
+			Code:    int(grpcErr.GetCode()),
+			Message: grpcErr.GetMessage(),
+		})
+		return
+	}
+	var converter errors.Converter
+	if stderrors.As(err, &converter) {
+		err = converter.ConvertGrpcError()
+	}
+
+	if s, ok := status.FromError(err); ok && s.Code() != codes.Unknown {
+		writeJSONError(w, HTTPStatusFromCode(s.Code()), errorResponse{
+			Code:    int(s.Code()),
+			Message: s.Message(),
+		})
+		return
+	}
+
+	logging.FromContext(r.Context()).Errorw("unhandled error", "err", err)
+	writeJSONError(w, http.StatusInternalServerError, errorResponse{
+		Code:    int(codes.Internal),
+		Message: http.StatusText(http.StatusInternalServerError),
+	})
+}
+
+// writeHttpError writes the message of err as is if it's JSON, and in the
+// envelope with the http status as code otherwise.
+func writeHttpError(w http.ResponseWriter, err errors.HttpError) {
+	message := err.GetMessage()
+	if !json.Valid([]byte(message)) {
+		writeJSONError(w, err.GetCode(), errorResponse{
+			Code:    err.GetCode(),
+			Message: message,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.GetCode())
+	_, _ = w.Write([]byte(message))
+}
+
+func writeJSONError(w http.ResponseWriter, code int, resp errorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// HTTPStatusFromCode converts a gRPC error code into the corresponding http
+// response status.
+// See: https://github.com/googleapis/googleapis/blob/master/google/rpc/code.proto
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.Unknown:
+		return http.StatusInternalServerError
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.Aborted:
+		return http.StatusConflict
+	case codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Internal:
+		return http.StatusInternalServerError
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DataLoss:
+		return http.StatusInternalServerError
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,297 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Genesic/mixednuts/logging"
+	"github.com/Genesic/mixednuts/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultHedgeDelay       = 100 * time.Millisecond
+	defaultHedgeMaxHedges   = 1
+	defaultHedgeBudgetRatio = 0.1
+	defaultHedgeBudgetBurst = 10
+	// hedgeSamples is the number of recent latencies the percentile delay is
+	// computed from, and hedgeMinSamples the number needed to use it.
+	hedgeSamples    = 256
+	hedgeMinSamples = 20
+	// hedgeRefresh is the number of new latencies after which the percentile
+	// delay is computed again.
+	hedgeRefresh = 16
+)
+
+// HedgingPolicy configures the hedged requests of a Client. When a request
+// didn't respond after a delay, an identical request is sent and the first
+// successful response is used, the others being canceled. Only GET, HEAD,
+// OPTIONS, PUT and DELETE requests are hedged: unlike retries, an
+// Idempotency-Key doesn't make a request hedgeable, since the server would see
+// concurrent requests with the same key.
+type HedgingPolicy struct {
+	// Delay is the wait before each hedge. Defaults to 100ms.
+	Delay time.Duration
+	// Percentile, between 0 and 1, uses this percentile of the recently
+	// observed latencies as delay instead, e.g. 0.95. Delay is used until
+	// enough latencies are observed.
+	Percentile float64
+	// MaxHedges is the number of requests sent besides the first one.
+	// Defaults to 1.
+	MaxHedges int
+	// BudgetRatio bounds the hedges to this ratio of the requests, with bursts
+	// of up to BudgetBurst hedges. Default to 0.1 and 10.
+	BudgetRatio float64
+	BudgetBurst int
+	// IsSuccess reports whether a response can be used. By default responses
+	// other than 5xx are used.
+	IsSuccess func(*http.Response) bool
+	// Registerer registers the hedge metrics. Defaults to
+	// prometheus.DefaultRegisterer.
+	Registerer prometheus.Registerer
+}
+
+func (p HedgingPolicy) withDefaults() HedgingPolicy {
+	if p.Delay <= 0 {
+		p.Delay = defaultHedgeDelay
+	}
+	if p.MaxHedges <= 0 {
+		p.MaxHedges = defaultHedgeMaxHedges
+	}
+	if p.BudgetRatio <= 0 {
+		p.BudgetRatio = defaultHedgeBudgetRatio
+	}
+	if p.BudgetBurst <= 0 {
+		p.BudgetBurst = defaultHedgeBudgetBurst
+	}
+	if p.IsSuccess == nil {
+		p.IsSuccess = func(res *http.Response) bool {
+			return res.StatusCode < http.StatusInternalServerError
+		}
+	}
+	if p.Registerer == nil {
+		p.Registerer = prometheus.DefaultRegisterer
+	}
+	return p
+}
+
+// WithHedging hedges the idempotent methods of the client according to
+// policy, to cut the tail latency of slow replicas. Hedges are sent to
+// another endpoint of the pool, if any, and each hedge is an attempt of its
+// own for the circuit breaker; canceled ones don't count as failures.
+func (c *Client) WithHedging(policy HedgingPolicy) *Client {
+	c = c.clone()
+	c.hedger = newHedger(policy.withDefaults())
+	return c
+}
+
+type hedger struct {
+	policy HedgingPolicy
+	hedges *prometheus.CounterVec
+
+	mu     sync.Mutex
+	tokens float64
+	// latencies is a ring of the recent latencies, next the index of the
+	// oldest one, and delay the cached percentile.
+	latencies []time.Duration
+	next      int
+	fresh     int
+	delay     time.Duration
+}
+
+func newHedger(policy HedgingPolicy) *hedger {
+	return &hedger{
+		policy: policy,
+		hedges: utils.RegisterCollector(policy.Registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_hedges_total",
+			Help: "Total number of hedged outbound http requests by host and outcome: won, lost or throttled.",
+		}, []string{"host", "outcome"})),
+		tokens: float64(policy.BudgetBurst),
+		delay:  policy.Delay,
+	}
+}
+
+// deposit adds the budget earned by a request.
+func (h *hedger) deposit() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tokens += h.policy.BudgetRatio
+	if burst := float64(h.policy.BudgetBurst); h.tokens > burst {
+		h.tokens = burst
+	}
+}
+
+// withdraw takes the budget of a hedge, if available.
+func (h *hedger) withdraw() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tokens < 1 {
+		return false
+	}
+	h.tokens--
+	return true
+}
+
+func (h *hedger) currentDelay() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.delay
+}
+
+// observe records the latency of a successful response.
+func (h *hedger) observe(latency time.Duration) {
+	if h.policy.Percentile <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.latencies) < hedgeSamples {
+		h.latencies = append(h.latencies, latency)
+	} else {
+		h.latencies[h.next] = latency
+		h.next = (h.next + 1) % hedgeSamples
+	}
+	if h.fresh++; h.fresh < hedgeRefresh || len(h.latencies) < hedgeMinSamples {
+		return
+	}
+	h.fresh = 0
+	sorted := append([]time.Duration(nil), h.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(h.policy.Percentile * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	h.delay = sorted[i]
+}
+
+type hedgeResult struct {
+	hedge   int
+	res     *http.Response
+	err     error
+	latency time.Duration
+}
+
+// isHedgeable reports whether requests of method can be sent concurrently.
+func isHedgeable(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// sendHedged makes an attempt of req, hedged if the client has a hedging
+// policy and req can be sent several times.
+func (c *Client) sendHedged(req *http.Request, attempt int, tried map[*endpoint]bool) (*http.Response, error) {
+	h := c.hedger
+	if h == nil || !isHedgeable(req.Method) || !canReplay(req) {
+		return c.sendToEndpoint(req, attempt, tried)
+	}
+	h.deposit()
+
+	results := make(chan hedgeResult, h.policy.MaxHedges+1)
+	cancels := make([]context.CancelFunc, 0, h.policy.MaxHedges+1)
+	launch := func(r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		cancels = append(cancels, cancel)
+		hedge := len(cancels) - 1
+		r = r.WithContext(ctx)
+		routed, e, err := c.pickEndpoint(r, tried)
+		if err != nil {
+			results <- hedgeResult{hedge: hedge, err: err}
+			return
+		}
+		go func() {
+			begin := time.Now()
+			res, err := c.sendRouted(r, routed, e, attempt)
+			results <- hedgeResult{hedge: hedge, res: res, err: err, latency: time.Since(begin)}
+		}()
+	}
+
+	launch(req)
+	pending := 1
+	timer := time.NewTimer(h.currentDelay())
+	defer timer.Stop()
+	var last hedgeResult
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if len(cancels) > h.policy.MaxHedges {
+				continue
+			}
+			if !h.withdraw() {
+				h.hedges.WithLabelValues(req.URL.Host, "throttled").Inc()
+				continue
+			}
+			next, err := rewind(req)
+			if err != nil {
+				continue
+			}
+			logging.FromContext(req.Context()).Infow("hedging outbound request",
+				"method", req.Method,
+				"url", req.URL.String(),
+				"attempt", attempt,
+				"hedge", len(cancels))
+			launch(next)
+			pending++
+			if len(cancels) <= h.policy.MaxHedges {
+				timer.Reset(h.currentDelay())
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil && h.policy.IsSuccess(r.res) {
+				h.observe(r.latency)
+				c.settleHedges(req, r, cancels, results, pending)
+				return r.res, nil
+			}
+			if last.res != nil {
+				discard(last.res)
+			}
+			last = r
+		}
+	}
+	// Every request failed: the last failure is returned, and its context
+	// released with its body.
+	c.settleHedges(req, last, cancels, results, 0)
+	return last.res, last.err
+}
+
+// settleHedges cancels the requests other than the one of winner, discards
+// the pending ones, and releases the context of winner once its body is
+// closed.
+func (c *Client) settleHedges(req *http.Request, winner hedgeResult, cancels []context.CancelFunc, results chan hedgeResult, pending int) {
+	host := req.URL.Host
+	for hedge, cancel := range cancels {
+		if hedge != winner.hedge {
+			cancel()
+		}
+	}
+	if len(cancels) > 1 {
+		if winner.hedge > 0 {
+			h := c.hedger
+			h.hedges.WithLabelValues(host, "won").Inc()
+			h.hedges.WithLabelValues(host, "lost").Add(float64(len(cancels) - 2))
+		} else {
+			c.hedger.hedges.WithLabelValues(host, "lost").Add(float64(len(cancels) - 1))
+		}
+	}
+	if pending > 0 {
+		go func() {
+			for i := 0; i < pending; i++ {
+				if r := <-results; r.res != nil {
+					discard(r.res)
+				}
+			}
+		}()
+	}
+
+	release := cancels[winner.hedge]
+	if winner.res == nil {
+		release()
+		return
+	}
+	winner.res.Body = &releaseOnClose{ReadCloser: winner.res.Body, release: release}
+}
@@ -1,16 +1,25 @@
 package http
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"testing"
 	"time"
 
 	"github.com/Genesic/mixednuts/http/middleware"
+	"github.com/Genesic/mixednuts/http/openapi"
+	"github.com/Genesic/mixednuts/logging"
 	"github.com/gorilla/mux"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -23,6 +32,7 @@ func TestMuxServer_Serve(t *testing.T) {
 		err := server.Serve(ctx)
 		So(err, ShouldBeNil)
 	}(ctx, app)
+	waitForServer("localhost:1234")
 
 	client := NewClient("localhost:1234").
 		WithHeaders("tracker", "yes").
@@ -47,6 +57,20 @@ func TestMuxServer_Serve(t *testing.T) {
 			So(code, ShouldEqual, 0)
 		})
 
+		Convey("test context propagation", func() {
+			ctx := context.WithValue(context.Background(), logging.RequestIDKey, "req-1")
+			resp := new(http.Header)
+			code, err := client.CommonDoWithJSONContext(ctx, http.MethodGet, "/header", nil, nil, resp)
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, http.StatusOK)
+			So(resp.Get(logging.RequestIdHeader), ShouldEqual, "req-1")
+
+			ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+			_, err = client.CommonDoWithJSONContext(ctx, http.MethodGet, "/timeout", nil, nil, nil)
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+
 		Convey("test form", func() {
 			form := url.Values{}
 			form.Add("trackers", "first")
@@ -60,9 +84,211 @@ func TestMuxServer_Serve(t *testing.T) {
 			So(resp, ShouldResemble, form)
 
 		})
+
+		Convey("test handler func errors", func() {
+			resp := new(errorResponse)
+			code, err := client.CommonDoWithJSON(http.MethodGet, "/error/http", nil, nil, nil)
+			So(code, ShouldEqual, http.StatusTeapot)
+			So(err.Error(), ShouldEqual, `{"reason":"teapot"}`)
+
+			code, err = client.CommonDoWithJSON(http.MethodGet, "/error/grpc", nil, nil, nil)
+			So(code, ShouldEqual, http.StatusNotFound)
+			So(json.Unmarshal([]byte(err.Error()), resp), ShouldBeNil)
+			So(resp.Code, ShouldEqual, codes.NotFound)
+			So(resp.Message, ShouldEqual, "user not found")
+
+			code, err = client.CommonDoWithJSON(http.MethodGet, "/error/plain", nil, nil, nil)
+			So(code, ShouldEqual, http.StatusInternalServerError)
+			So(json.Unmarshal([]byte(err.Error()), resp), ShouldBeNil)
+			So(resp.Message, ShouldEqual, http.StatusText(http.StatusInternalServerError))
+
+			code, err = client.CommonDoWithJSON(http.MethodGet, "/error/wrapped", nil, nil, nil)
+			So(code, ShouldEqual, http.StatusForbidden)
+			So(json.Unmarshal([]byte(err.Error()), resp), ShouldBeNil)
+			So(resp.Code, ShouldEqual, http.StatusForbidden)
+			So(resp.Message, ShouldEqual, "not allowed")
+		})
+
+		Convey("test openapi document", func() {
+			doc := new(openapi.Document)
+			code, err := client.CommonDoWithJSON(http.MethodGet, "/openapi.json", nil, nil, doc)
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, http.StatusOK)
+			So(doc.Info.Title, ShouldEqual, "mock")
+			So(doc.Paths, ShouldContainKey, "/ping")
+			So(doc.Paths, ShouldNotContainKey, "/openapi.json")
+
+			form := (*doc.Paths["/form"])["post"]
+			So(form.Summary, ShouldEqual, "echo the form")
+			So(form.RequestBody.Content, ShouldContainKey, "application/x-www-form-urlencoded")
+			So(form.Parameters, ShouldHaveLength, 1)
+			So(form.Parameters[0].Name, ShouldEqual, "dry-run")
+			So(form.Responses, ShouldContainKey, "400")
+
+			schema := doc.Components.Schemas["formRequest"]
+			So(schema.Required, ShouldResemble, []string{"trackers"})
+			So(*schema.Properties["trackers"].MaxItems, ShouldEqual, 2)
+		})
+
+		Convey("test route table endpoint", func() {
+			var table []RouteInfo
+			code, err := client.CommonDoWithJSON(http.MethodGet, "/debug/routes", nil, nil, &table)
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, http.StatusOK)
+			So(table[0].Path, ShouldEqual, "/header")
+			So(table[0].Source, ShouldEqual, "controller:*http.mockController")
+			So(table[0].Middlewares, ShouldHaveLength, 4)
+		})
+	})
+}
+
+func TestMuxServer_H2C(t *testing.T) {
+	ctx := context.Background()
+	app := NewMuxServer(1235, Config{H2C: H2CConfig{Enabled: true, MaxConcurrentStreams: 10}}).
+		WithMiddlewares(
+			middleware.RequestIDMiddleware,
+			middleware.ResponseMiddleware,
+			middleware.LogMiddleware(),
+			middleware.RequestDurationMiddleware,
+		).
+		WithControllers(&mockController{})
+	go func() {
+		_ = app.Serve(ctx)
+	}()
+	defer func() {
+		_ = app.Shutdown(ctx)
+	}()
+	waitForServer("localhost:1235")
+
+	Convey("test h2c", t, func() {
+		Convey("with prior knowledge", func() {
+			client := &http.Client{Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+					return net.Dial(network, addr)
+				},
+			}}
+			resp, err := client.Get("http://localhost:1235/header")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Proto, ShouldEqual, "HTTP/2.0")
+		})
+
+		Convey("with upgrade", func() {
+			conn, err := net.Dial("tcp", "localhost:1235")
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			_, _ = conn.Write([]byte("GET /header HTTP/1.1\r\nHost: localhost\r\n" +
+				"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAARAAAAAAAIAAAAA\r\n\r\n"))
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusSwitchingProtocols)
+			So(resp.Header.Get("Upgrade"), ShouldEqual, "h2c")
+		})
+
+		Convey("still serves HTTP/1.1", func() {
+			resp, err := http.Get("http://localhost:1235/header")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Proto, ShouldEqual, "HTTP/1.1")
+		})
 	})
 }
 
+func TestMuxServer_RouteTable(t *testing.T) {
+	Convey("test route table", t, func() {
+		server := NewMuxServer(0, Config{}).
+			WithMiddlewares(middleware.RequestIDMiddleware).
+			WithControllers(&usersController{}).
+			WithAdditionalHandlers("/ping", pingHandler())
+		router, sources := server.newRouter()
+		table := buildRouteTable(router, sources, middlewareNames(server.middlewares))
+
+		So(table, ShouldHaveLength, 8)
+		So(table[0].Path, ShouldEqual, "/users/{id}")
+		So(table[0].Methods, ShouldResemble, []string{http.MethodGet})
+		So(table[0].Source, ShouldEqual, "controller:*http.usersController")
+		So(table[0].Middlewares, ShouldResemble, []string{"middleware.RequestIDMiddleware"})
+		So(table[0].ShadowedBy, ShouldBeEmpty)
+
+		// same template and method
+		So(table[1].ShadowedBy, ShouldEqual, "/users/{id}")
+		// literal path matched by an earlier template
+		So(table[2].Path, ShouldEqual, "/users/me")
+		So(table[2].ShadowedBy, ShouldEqual, "/users/{id}")
+		// different method
+		So(table[3].ShadowedBy, ShouldBeEmpty)
+
+		// route without method matcher matching the method of an earlier one
+		So(table[5].Path, ShouldEqual, "/files/readme")
+		So(table[5].ShadowedBy, ShouldEqual, "/files/{name}")
+
+		// path which isn't a valid request URI
+		So(table[6].Path, ShouldEqual, "/files/%")
+		So(table[6].ShadowedBy, ShouldBeEmpty)
+
+		So(table[7].Path, ShouldEqual, "/ping")
+		So(table[7].Source, ShouldEqual, routeSourceAdditional)
+		So(table[7].Middlewares, ShouldBeEmpty)
+	})
+}
+
+type usersController struct{}
+
+func (u *usersController) RegisterHandlers(router *mux.Router) {
+	router.Methods(http.MethodGet).Path("/users/{id}").Handler(pingHandler())
+	router.Methods(http.MethodGet).Path("/users/{id}").Handler(pingHandler())
+	router.Methods(http.MethodGet).Path("/users/me").Handler(pingHandler())
+	router.Methods(http.MethodDelete).Path("/users/me").Handler(pingHandler())
+	router.Methods(http.MethodDelete).Path("/files/{name}").Handler(pingHandler())
+	router.Path("/files/readme").Handler(pingHandler())
+	router.Methods(http.MethodGet).Path("/files/%").Handler(pingHandler())
+}
+
+func TestMuxServer_OpenAPIDocument(t *testing.T) {
+	Convey("test openapi document generation", t, func() {
+		doc, err := newApp(0).OpenAPIDocument()
+		So(err, ShouldBeNil)
+		So(doc.Paths, ShouldContainKey, "/header")
+		So((*doc.Paths["/header"])["get"].OperationID, ShouldEqual, "getHeader")
+
+		bs, err := doc.JSON()
+		So(err, ShouldBeNil)
+		again, _ := newApp(0).OpenAPIDocument()
+		bs2, _ := again.JSON()
+		So(string(bs), ShouldEqual, string(bs2))
+	})
+}
+
+type formRequest struct {
+	DryRun   bool     `query:"dry-run"`
+	Trackers []string `json:"trackers" form:"trackers" validate:"required,max=2"`
+}
+
+func waitForServer(addr string) {
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			_ = conn.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+type teapotError struct{}
+
+func (teapotError) Error() string      { return "teapot" }
+func (teapotError) GetCode() int       { return http.StatusTeapot }
+func (teapotError) GetMessage() string { return `{"reason":"teapot"}` }
+
+type forbiddenError struct{}
+
+func (forbiddenError) Error() string      { return "forbidden" }
+func (forbiddenError) GetCode() int       { return http.StatusForbidden }
+func (forbiddenError) GetMessage() string { return "not allowed" }
+
 func pingHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -86,7 +312,11 @@ func newApp(port int) *MuxServer {
 		WithControllers(controllers...).
 		WithAdditionalHandlers(
 			"/ping", pingHandler(),
-		)
+		).
+		WithOpenAPI("/openapi.json", openapi.Config{
+			Info: openapi.Info{Title: "mock", Version: "1.0.0"},
+		}).
+		WithRouteTableEndpoint("/debug/routes")
 
 	return app
 }
@@ -96,6 +326,21 @@ type mockController struct {
 	wait      time.Duration
 }
 
+func (m *mockController) DescribeRoutes() []openapi.Route {
+	return []openapi.Route{
+		{
+			Method:   http.MethodPost,
+			Path:     "/form",
+			Summary:  "echo the form",
+			Request:  formRequest{},
+			Response: url.Values{},
+			Errors: []openapi.ErrorResponse{
+				{Status: http.StatusBadRequest},
+			},
+		},
+	}
+}
+
 func (m *mockController) RegisterHandlers(router *mux.Router) {
 	router.
 		Methods(http.MethodGet).
@@ -124,4 +369,32 @@ func (m *mockController) RegisterHandlers(router *mux.Router) {
 			w.WriteHeader(http.StatusOK)
 			_ = json.NewEncoder(w).Encode(r.Form)
 		}))
+
+	router.
+		Methods(http.MethodGet).
+		Path("/error/http").
+		Handler(HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			return teapotError{}
+		}))
+
+	router.
+		Methods(http.MethodGet).
+		Path("/error/wrapped").
+		Handler(HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			return fmt.Errorf("check permission: %w", forbiddenError{})
+		}))
+
+	router.
+		Methods(http.MethodGet).
+		Path("/error/grpc").
+		Handler(HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			return status.Error(codes.NotFound, "user not found")
+		}))
+
+	router.
+		Methods(http.MethodGet).
+		Path("/error/plain").
+		Handler(HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			return errors.New("boom")
+		}))
 }
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Genesic/mixednuts/clientip"
+	"github.com/gorilla/mux"
+)
+
+// ClientIPMiddleware resolves the IP of the client with resolver and stores it
+// in the request context, so it's shared by logging, rate limiting and auth.
+// It must be installed before LogMiddleware for the logs to show the resolved
+// IP rather than the remote address. See clientip.FromContext.
+func ClientIPMiddleware(resolver *clientip.Resolver) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := resolver.Resolve(r.RemoteAddr, r.Header)
+			next.ServeHTTP(w, r.WithContext(clientip.WithIP(r.Context(), ip)))
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	headerOrigin                        = "Origin"
+	headerVary                          = "Vary"
+	headerAccessControlRequestMethod    = "Access-Control-Request-Method"
+	headerAccessControlRequestHeaders   = "Access-Control-Request-Headers"
+	headerAccessControlAllowOrigin      = "Access-Control-Allow-Origin"
+	headerAccessControlAllowMethods     = "Access-Control-Allow-Methods"
+	headerAccessControlAllowHeaders     = "Access-Control-Allow-Headers"
+	headerAccessControlAllowCredentials = "Access-Control-Allow-Credentials"
+	headerAccessControlExposeHeaders    = "Access-Control-Expose-Headers"
+	headerAccessControlMaxAge           = "Access-Control-Max-Age"
+)
+
+// CORSPolicy is the CORS configuration of a group of routes.
+type CORSPolicy struct {
+	// PathPrefix selects the routes the policy applies to, matching whole path
+	// segments: "/api" matches "/api" and "/api/orders" but not "/apiary".
+	// When several policies match a path, the one with the longest prefix is
+	// used. An empty prefix matches every path.
+	PathPrefix string
+	// AllowedOrigins are either exact origins such as "https://example.com",
+	// origins with a wildcard subdomain such as "https://*.example.com", or "*"
+	// to allow every origin. "*" can't be combined with AllowCredentials.
+	AllowedOrigins []string
+	// AllowedOriginPatterns are matched against the whole origin, i.e. they
+	// are anchored at both ends when the policy is built.
+	AllowedOriginPatterns []*regexp.Regexp
+	// AllowedMethods defaults to the methods of the route being requested.
+	AllowedMethods []string
+	// AllowedHeaders defaults to the headers requested by the preflight.
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long the preflight response can be cached.
+	MaxAge time.Duration
+}
+
+func (p *CORSPolicy) allowOrigin(origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok {
+			if len(origin) > len(prefix)+len(suffix) &&
+				strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+				!strings.Contains(origin[len(prefix):len(origin)-len(suffix)], "/") {
+				return true
+			}
+		}
+	}
+	for _, pattern := range p.AllowedOriginPatterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CORSPolicy) allowAnyOrigin() bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+type corsHandler struct {
+	next     http.Handler
+	router   *mux.Router
+	policies []CORSPolicy
+}
+
+// CORS applies policies to the requests served by next, and answers preflight
+// requests of the routes registered on router, even when those routes are
+// restricted to other methods with Methods(...). Preflight requests of
+// unknown routes are passed to next.
+//
+// It should wrap the router rather than being added with Use, since mux
+// doesn't run middlewares when no route matches the OPTIONS method. It panics
+// if a policy allows credentials from any origin, which browsers forbid since
+// any site could read the credentialed responses.
+func CORS(router *mux.Router, policies ...CORSPolicy) func(http.Handler) http.Handler {
+	for _, policy := range policies {
+		if policy.AllowCredentials && policy.allowAnyOrigin() {
+			panic(fmt.Sprintf("cors policy %q allows credentials from any origin", policy.PathPrefix))
+		}
+	}
+	sorted := append([]CORSPolicy(nil), policies...)
+	for i := range sorted {
+		sorted[i].AllowedOriginPatterns = anchorPatterns(sorted[i].AllowedOriginPatterns)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].PathPrefix) > len(sorted[j].PathPrefix)
+	})
+
+	return func(next http.Handler) http.Handler {
+		return &corsHandler{
+			next:     next,
+			router:   router,
+			policies: sorted,
+		}
+	}
+}
+
+// anchorPatterns returns copies of patterns which only match whole origins,
+// so `https://[a-z]+\.example\.com` doesn't match
+// "https://x.example.com.evil.io".
+func anchorPatterns(patterns []*regexp.Regexp) []*regexp.Regexp {
+	anchored := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		anchored[i] = regexp.MustCompile(`^(?:` + pattern.String() + `)$`)
+	}
+	return anchored
+}
+
+func (h *corsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	policy := h.policy(r.URL.Path)
+	if policy == nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	// Responses of the path depend on the Origin, even without one, so shared
+	// caches don't serve them to other origins.
+	header := w.Header()
+	header.Add(headerVary, headerOrigin)
+	origin := r.Header.Get(headerOrigin)
+	if origin == "" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	if r.Method == http.MethodOptions && r.Header.Get(headerAccessControlRequestMethod) != "" {
+		h.preflight(w, r, policy, origin)
+		return
+	}
+
+	if policy.allowOrigin(origin) {
+		h.setAllowOrigin(header, policy, origin)
+		if len(policy.ExposedHeaders) > 0 {
+			header.Set(headerAccessControlExposeHeaders, strings.Join(policy.ExposedHeaders, ", "))
+		}
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+func (h *corsHandler) preflight(w http.ResponseWriter, r *http.Request, policy *CORSPolicy, origin string) {
+	method := strings.ToUpper(r.Header.Get(headerAccessControlRequestMethod))
+
+	// Check the route exists for the requested method.
+	target := r.Clone(r.Context())
+	target.Method = method
+	var match mux.RouteMatch
+	if !h.router.Match(target, &match) || match.MatchErr != nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	header := w.Header()
+	header.Add(headerVary, headerAccessControlRequestMethod)
+	header.Add(headerVary, headerAccessControlRequestHeaders)
+	if !policy.allowOrigin(origin) || !allowMethod(policy, method) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	h.setAllowOrigin(header, policy, origin)
+	if len(policy.AllowedMethods) > 0 {
+		header.Set(headerAccessControlAllowMethods, strings.Join(policy.AllowedMethods, ", "))
+	} else {
+		header.Set(headerAccessControlAllowMethods, method)
+	}
+	if len(policy.AllowedHeaders) > 0 {
+		header.Set(headerAccessControlAllowHeaders, strings.Join(policy.AllowedHeaders, ", "))
+	} else if requested := r.Header.Get(headerAccessControlRequestHeaders); requested != "" {
+		header.Set(headerAccessControlAllowHeaders, requested)
+	}
+	if policy.MaxAge > 0 {
+		header.Set(headerAccessControlMaxAge, strconv.Itoa(int(policy.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *corsHandler) setAllowOrigin(header http.Header, policy *CORSPolicy, origin string) {
+	if policy.allowAnyOrigin() {
+		header.Set(headerAccessControlAllowOrigin, "*")
+	} else {
+		header.Set(headerAccessControlAllowOrigin, origin)
+	}
+	if policy.AllowCredentials {
+		header.Set(headerAccessControlAllowCredentials, "true")
+	}
+}
+
+func (h *corsHandler) policy(path string) *CORSPolicy {
+	for i := range h.policies {
+		if matchPathPrefix(path, h.policies[i].PathPrefix) {
+			return &h.policies[i]
+		}
+	}
+	return nil
+}
+
+// matchPathPrefix reports whether path is prefix or below it.
+func matchPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+func allowMethod(policy *CORSPolicy, method string) bool {
+	if len(policy.AllowedMethods) == 0 {
+		// The route matched the method.
+		return true
+	}
+	for _, m := range policy.AllowedMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCORS(t *testing.T) {
+	Convey("test cors", t, func() {
+		router := mux.NewRouter()
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		router.Methods(http.MethodPost).Path("/api/orders").Handler(ok)
+		router.Methods(http.MethodGet).Path("/public/status").Handler(ok)
+		router.Methods(http.MethodGet).Path("/apiary").Handler(ok)
+
+		h := CORS(router,
+			CORSPolicy{
+				PathPrefix:            "/api",
+				AllowedOrigins:        []string{"https://app.example.com", "https://*.example.org"},
+				AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`^http://localhost:\d+$`), regexp.MustCompile(`https://[a-z]+\.example\.com`)},
+				ExposedHeaders:        []string{"X-Request-Id"},
+				AllowCredentials:      true,
+				MaxAge:                10 * time.Minute,
+			},
+			CORSPolicy{
+				AllowedOrigins: []string{"*"},
+			},
+		)(router)
+
+		do := func(method, path, origin string, header ...string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(method, path, nil)
+			if origin != "" {
+				r.Header.Set("Origin", origin)
+			}
+			for i := 0; i < len(header); i += 2 {
+				r.Header.Set(header[i], header[i+1])
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("answer preflight of a route restricted to other methods", func() {
+			w := do(http.MethodOptions, "/api/orders", "https://app.example.com",
+				"Access-Control-Request-Method", "POST",
+				"Access-Control-Request-Headers", "Content-Type, Idempotency-Key")
+			So(w.Code, ShouldEqual, http.StatusNoContent)
+			So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://app.example.com")
+			So(w.Header().Get("Access-Control-Allow-Methods"), ShouldEqual, "POST")
+			So(w.Header().Get("Access-Control-Allow-Headers"), ShouldEqual, "Content-Type, Idempotency-Key")
+			So(w.Header().Get("Access-Control-Allow-Credentials"), ShouldEqual, "true")
+			So(w.Header().Get("Access-Control-Max-Age"), ShouldEqual, "600")
+		})
+
+		Convey("match wildcard subdomains and patterns", func() {
+			preflight := func(origin string) int {
+				return do(http.MethodOptions, "/api/orders", origin, "Access-Control-Request-Method", "POST").Code
+			}
+			So(preflight("https://shop.example.org"), ShouldEqual, http.StatusNoContent)
+			So(preflight("https://a.b.example.org"), ShouldEqual, http.StatusNoContent)
+			So(preflight("https://example.org"), ShouldEqual, http.StatusForbidden)
+			So(preflight("https://evil.com/.example.org"), ShouldEqual, http.StatusForbidden)
+			So(preflight("http://localhost:3000"), ShouldEqual, http.StatusNoContent)
+			So(preflight("https://x.example.com"), ShouldEqual, http.StatusNoContent)
+			So(preflight("https://x.example.com.evil.io"), ShouldEqual, http.StatusForbidden)
+			So(preflight("http://evil.io/https://x.example.com"), ShouldEqual, http.StatusForbidden)
+			So(preflight("https://evil.com"), ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("reject preflight of unknown routes and methods", func() {
+			w := do(http.MethodOptions, "/api/orders", "https://app.example.com", "Access-Control-Request-Method", "DELETE")
+			So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			w = do(http.MethodOptions, "/api/unknown", "https://app.example.com", "Access-Control-Request-Method", "GET")
+			So(w.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("decorate actual requests", func() {
+			w := do(http.MethodPost, "/api/orders", "https://app.example.com")
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://app.example.com")
+			So(w.Header().Get("Access-Control-Expose-Headers"), ShouldEqual, "X-Request-Id")
+			So(w.Header().Values("Vary"), ShouldContain, "Origin")
+
+			w = do(http.MethodPost, "/api/orders", "https://evil.com")
+			So(w.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+		})
+
+		Convey("use the policy of the route group", func() {
+			w := do(http.MethodGet, "/public/status", "https://anyone.com")
+			So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "*")
+			So(w.Header().Get("Access-Control-Allow-Credentials"), ShouldBeEmpty)
+
+			w = do(http.MethodGet, "/public/status", "")
+			So(w.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+			So(w.Header().Values("Vary"), ShouldContain, "Origin")
+
+			// "/api" doesn't match other segments.
+			w = do(http.MethodGet, "/apiary", "https://anyone.com")
+			So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "*")
+		})
+
+		Convey("reject credentials with any origin", func() {
+			So(func() { CORS(router, CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}) }, ShouldPanic)
+		})
+	})
+}
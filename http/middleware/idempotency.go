@@ -0,0 +1,346 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Genesic/mixednuts/clientip"
+	"github.com/Genesic/mixednuts/logging"
+	"github.com/gorilla/mux"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	defaultIdempotencyTTL     = 24 * time.Hour
+	defaultIdempotencyMaxBody = 1 << 20
+	maxIdempotencyKeyLength   = 255
+	idempotencySweepThreshold = 1024
+)
+
+var (
+	// ErrIdempotencyInFlight is returned by IdempotencyStore.Begin when a
+	// request with the same key is still being processed.
+	ErrIdempotencyInFlight = errors.New("a request with the same idempotency key is in progress")
+	// ErrIdempotencyKeyReuse is returned by IdempotencyStore.Begin when the key
+	// was used by a request with a different payload.
+	ErrIdempotencyKeyReuse = errors.New("idempotency key was used by a different request")
+)
+
+// StoredResponse is the response recorded for an idempotency key.
+type StoredResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+// IdempotencyStore keeps the responses of requests carrying an idempotency
+// key. Implementations must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Begin reserves key for the request identified by fingerprint. It returns
+	// the stored response if the key has been completed by the same request,
+	// ErrIdempotencyInFlight if it's reserved but not completed yet, and
+	// ErrIdempotencyKeyReuse if the fingerprint doesn't match.
+	Begin(ctx context.Context, key, fingerprint string, ttl time.Duration) (*StoredResponse, error)
+	// Complete stores the response of a reserved key until ttl expires.
+	Complete(ctx context.Context, key string, resp *StoredResponse, ttl time.Duration) error
+	// Release removes the reservation so the request can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+type IdempotencyConfig struct {
+	Store IdempotencyStore
+	// TTL is how long a response is replayed for. Defaults to 24 hours.
+	TTL time.Duration
+	// Methods that honor the idempotency key. Defaults to POST and PATCH.
+	Methods []string
+	// Scope returns the identity of the caller of a request, so keys of
+	// different callers don't collide. Defaults to the Authorization header,
+	// or the client IP for anonymous requests.
+	Scope func(*http.Request) string
+	// MaxBodyBytes is the size of the largest body buffered to fingerprint a
+	// request; larger ones are answered with 413. Defaults to 1MiB.
+	MaxBodyBytes int64
+}
+
+// defaultIdempotencyScope scopes keys by credentials, or by client IP, as
+// resolved by ClientIPMiddleware if it ran.
+func defaultIdempotencyScope(r *http.Request) string {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		return "auth:" + authorization
+	}
+	if ip := clientip.FromContext(r.Context()); ip != "" {
+		return "ip:" + ip
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return "ip:" + host
+	}
+	return "ip:" + r.RemoteAddr
+}
+
+// IdempotencyMiddleware replays the first response of requests with the same
+// Idempotency-Key header from the same caller, see IdempotencyConfig.Scope.
+// Requests without the header pass through. Only the headers set by the
+// handler are replayed, not the ones of outer middlewares such as the request
+// ID.
+//
+// Concurrent requests with a key in progress are answered with 409, and
+// reusing a key with a different method, path or body with 422. Responses with
+// 5xx status are not stored so the client can retry.
+//
+// ResponseMiddleware should be placed before this middleware.
+func IdempotencyMiddleware(cfg IdempotencyConfig) mux.MiddlewareFunc {
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryIdempotencyStore()
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultIdempotencyTTL
+	}
+	if len(cfg.Methods) == 0 {
+		cfg.Methods = []string{http.MethodPost, http.MethodPatch}
+	}
+	if cfg.Scope == nil {
+		cfg.Scope = defaultIdempotencyScope
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = defaultIdempotencyMaxBody
+	}
+	methods := make(map[string]struct{}, len(cfg.Methods))
+	for _, method := range cfg.Methods {
+		methods[method] = struct{}{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if _, ok := methods[r.Method]; !ok || key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+			logger := logging.FromContext(ctx)
+
+			rw, ok := w.(*ResponseWriter)
+			if !ok {
+				logger.Fatalw("ResponseMiddleware should be placed before IdempotencyMiddleware")
+			}
+
+			if len(key) > maxIdempotencyKeyLength {
+				writeErrorResponse(rw, http.StatusBadRequest, errors.New("idempotency key is too long"))
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, cfg.MaxBodyBytes+1))
+			var maxBytesErr *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytesErr):
+				writeErrorResponse(rw, http.StatusRequestEntityTooLarge, err)
+				return
+			case err != nil:
+				writeErrorResponse(rw, http.StatusBadRequest, err)
+				return
+			case int64(len(body)) > cfg.MaxBodyBytes:
+				writeErrorResponse(rw, http.StatusRequestEntityTooLarge, &http.MaxBytesError{Limit: cfg.MaxBodyBytes})
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			key = scopedKey(cfg.Scope(r), key)
+			stored, err := cfg.Store.Begin(ctx, key, fingerprint(r, body), cfg.TTL)
+			switch {
+			case errors.Is(err, ErrIdempotencyInFlight):
+				writeErrorResponse(rw, http.StatusConflict, err)
+				return
+			case errors.Is(err, ErrIdempotencyKeyReuse):
+				writeErrorResponse(rw, http.StatusUnprocessableEntity, err)
+				return
+			case err != nil:
+				logger.Errorw("failed to begin idempotent request", "err", err)
+				writeErrorResponse(rw, http.StatusInternalServerError, err)
+				return
+			case stored != nil:
+				replay(rw, stored)
+				return
+			}
+
+			rw.CaptureBody()
+			outer := rw.Header().Clone()
+			completed := false
+			defer func() {
+				if !completed {
+					if err := cfg.Store.Release(ctx, key); err != nil {
+						logger.Errorw("failed to release idempotency key", "err", err)
+					}
+				}
+			}()
+
+			next.ServeHTTP(rw, r)
+
+			if rw.GetStatusCode() >= http.StatusInternalServerError {
+				return
+			}
+			resp := &StoredResponse{
+				StatusCode: rw.GetStatusCode(),
+				Header:     handlerHeader(outer, rw.Header()),
+				Body:       append([]byte(nil), rw.GetCapturedBody()...),
+			}
+			if err := cfg.Store.Complete(ctx, key, resp, cfg.TTL); err != nil {
+				logger.Errorw("failed to store idempotent response", "err", err)
+				return
+			}
+			completed = true
+		})
+	}
+}
+
+// scopedKey prefixes key with a hash of scope, so credentials aren't kept in
+// the store.
+func scopedKey(scope, key string) string {
+	sum := sha256.Sum256([]byte(scope))
+	return fmt.Sprintf("%x:%s", sum[:16], key)
+}
+
+// handlerHeader returns the headers of header set or changed since outer, the
+// header before the handler ran.
+func handlerHeader(outer, header http.Header) http.Header {
+	written := make(http.Header)
+	for k, v := range header {
+		if prev, ok := outer[k]; !ok || !equalValues(prev, v) {
+			written[k] = append([]string(nil), v...)
+		}
+	}
+	return written
+}
+
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.RequestURI()))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(rw *ResponseWriter, stored *StoredResponse) {
+	header := rw.Header()
+	for k, v := range stored.Header {
+		header[k] = v
+	}
+	header.Set(IdempotentReplayedHeader, "true")
+	rw.WriteHeader(stored.StatusCode)
+	_, _ = rw.Write(stored.Body)
+}
+
+type errorResponse struct {
+	Message string `json:"message"`
+}
+
+// writeErrorResponse answers the request with status and a JSON message, and
+// records err on the ResponseWriter for logging.
+func writeErrorResponse(rw *ResponseWriter, status int, err error) {
+	rw.WriteError(err)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(errorResponse{Message: err.Error()})
+}
+
+type idempotencyEntry struct {
+	fingerprint string
+	response    *StoredResponse
+	expiresAt   time.Time
+}
+
+// MemoryIdempotencyStore is an in-process IdempotencyStore. It's suitable for
+// a single instance deployment or for tests.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	now     func() time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		entries: make(map[string]*idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryIdempotencyStore) Begin(_ context.Context, key, fingerprint string, ttl time.Duration) (*StoredResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if len(s.entries) >= idempotencySweepThreshold {
+		s.sweep(now)
+	}
+
+	entry, ok := s.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		s.entries[key] = &idempotencyEntry{
+			fingerprint: fingerprint,
+			expiresAt:   now.Add(ttl),
+		}
+		return nil, nil
+	}
+
+	if entry.fingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyReuse
+	}
+	if entry.response == nil {
+		return nil, ErrIdempotencyInFlight
+	}
+	return entry.response, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(_ context.Context, key string, resp *StoredResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return errors.New("idempotency key is not reserved")
+	}
+	entry.response = resp
+	entry.expiresAt = s.now().Add(ttl)
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	for key, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	Convey("test idempotency middleware", t, func() {
+		var calls int32
+		status := http.StatusCreated
+		started, block := make(chan struct{}), make(chan struct{})
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&calls, 1)
+			if r.URL.Path == "/slow" {
+				close(started)
+				<-block
+			}
+			w.Header().Set("X-Order", fmt.Sprint(n))
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte("order-"))
+			_, _ = w.Write([]byte(fmt.Sprint(n)))
+		})
+		var requestID int32
+		outer := func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Request-Id", fmt.Sprint(atomic.AddInt32(&requestID, 1)))
+				next.ServeHTTP(w, r)
+			})
+		}
+		h := ResponseMiddleware(outer(IdempotencyMiddleware(IdempotencyConfig{MaxBodyBytes: 64})(handler)))
+
+		do := func(path, key, body string, header ...string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+			if key != "" {
+				r.Header.Set(IdempotencyKeyHeader, key)
+			}
+			for i := 0; i < len(header); i += 2 {
+				r.Header.Set(header[i], header[i+1])
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("replay the first response", func() {
+			first := do("/orders", "k1", `{"amount":1}`)
+			second := do("/orders", "k1", `{"amount":1}`)
+			So(calls, ShouldEqual, 1)
+			So(second.Code, ShouldEqual, http.StatusCreated)
+			So(second.Body.String(), ShouldEqual, first.Body.String())
+			So(second.Body.String(), ShouldEqual, "order-1")
+			So(second.Header().Get("X-Order"), ShouldEqual, "1")
+			So(second.Header().Get(IdempotentReplayedHeader), ShouldEqual, "true")
+			// Headers of outer middlewares aren't replayed.
+			So(second.Header().Get("X-Request-Id"), ShouldEqual, "2")
+
+			do("/orders", "", `{"amount":1}`)
+			So(calls, ShouldEqual, 2)
+		})
+
+		Convey("scope keys by caller", func() {
+			do("/orders", "k5", `{"amount":1}`, "Authorization", "Bearer alice")
+			w := do("/orders", "k5", `{"amount":1}`, "Authorization", "Bearer bob")
+			So(w.Code, ShouldEqual, http.StatusCreated)
+			So(w.Header().Get(IdempotentReplayedHeader), ShouldBeEmpty)
+			So(calls, ShouldEqual, 2)
+
+			w = do("/orders", "k5", `{"amount":1}`, "Authorization", "Bearer alice")
+			So(w.Header().Get(IdempotentReplayedHeader), ShouldEqual, "true")
+			So(calls, ShouldEqual, 2)
+		})
+
+		Convey("reject oversized bodies", func() {
+			w := do("/orders", "k6", strings.Repeat("a", 65))
+			So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(calls, ShouldEqual, 0)
+		})
+
+		Convey("reject key reuse with a different payload", func() {
+			do("/orders", "k2", `{"amount":1}`)
+			w := do("/orders", "k2", `{"amount":2}`)
+			So(w.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			So(calls, ShouldEqual, 1)
+		})
+
+		Convey("reject concurrent duplicates", func() {
+			done := make(chan *httptest.ResponseRecorder)
+			go func() { done <- do("/slow", "k3", "") }()
+			<-started
+			w := do("/slow", "k3", "")
+			So(w.Code, ShouldEqual, http.StatusConflict)
+			close(block)
+			So((<-done).Code, ShouldEqual, http.StatusCreated)
+		})
+
+		Convey("don't store server errors", func() {
+			status = http.StatusServiceUnavailable
+			do("/orders", "k4", "")
+			status = http.StatusCreated
+			w := do("/orders", "k4", "")
+			So(w.Code, ShouldEqual, http.StatusCreated)
+			So(calls, ShouldEqual, 2)
+		})
+	})
+}
@@ -3,12 +3,15 @@ package middleware
 import (
 	"bytes"
 	"fmt"
+	"github.com/Genesic/mixednuts/clientip"
 	"github.com/Genesic/mixednuts/logging"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 	"io"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 )
 
 const (
@@ -16,6 +19,8 @@ const (
 	responseHeaderPrefix = "resp-"
 
 	requestLogMsg = "req-log"
+
+	streamEventsKey = "streamEvents"
 )
 
 var includeHeader = map[string]struct{}{
@@ -26,7 +31,9 @@ type logMiddlewareHandler struct {
 	next http.Handler
 }
 
-type httpRequest struct {
+// HttpRequest is the shape of the httpRequest log field, shared by the logs
+// of inbound and outbound requests.
+type HttpRequest struct {
 	Method    string `json:"requestMethod"`
 	URL       string `json:"requestUrl"`
 	ReqSize   int64  `json:"requestSize"`
@@ -50,7 +57,7 @@ func (h *logMiddlewareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		logger.Fatalw("ResponseMiddleware should be placed before LogMiddleware")
 	}
 
-	var requestField httpRequest
+	var requestField HttpRequest
 
 	fields := populateRequestHeaderFields(r, &requestField)
 
@@ -64,6 +71,9 @@ func (h *logMiddlewareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	// fields = append(fields, zap.String(responseBodyKey, string(rw.GetBody())))
 	fields = append(fields, populateResponseHeaderFields(rw, &requestField)...)
 	fields = append(fields, zap.Any("httpRequest", requestField))
+	if events, ok := rw.GetStreamEvents(); ok {
+		fields = append(fields, zap.Int(streamEventsKey, events))
+	}
 
 	if rw.GetError() != nil {
 		logger.Desugar().Error(requestLogMsg, fields...)
@@ -72,20 +82,23 @@ func (h *logMiddlewareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-func populateRequestHeaderFields(r *http.Request, requestField *httpRequest) []zap.Field {
+func populateRequestHeaderFields(r *http.Request, requestField *HttpRequest) []zap.Field {
 	requestField.Method = r.Method
 	requestField.URL = r.URL.String()
 	requestField.ReqSize = r.ContentLength
-	requestField.IP = r.Header.Get("x-real-ip")
+	requestField.IP = clientip.FromContext(r.Context())
+	if requestField.IP == "" {
+		// ClientIPMiddleware isn't installed, the remote address is the only
+		// address which can't be spoofed.
+		requestField.IP, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
 
 	return generateHeaderLogFields(requestHeaderPrefix, r.Header)
 
 }
 
-func populateResponseHeaderFields(rw *ResponseWriter, requestField *httpRequest) []zap.Field {
-	requestField.Latency = strings.TrimRight(strings.TrimRight(
-		fmt.Sprintf("%.4f", rw.GetRequestDuration().Seconds()),
-		"0"), ".") + "s"
+func populateResponseHeaderFields(rw *ResponseWriter, requestField *HttpRequest) []zap.Field {
+	requestField.Latency = FormatLatency(rw.GetRequestDuration())
 	requestField.RespSize = len(rw.GetBody())
 	requestField.Status = rw.GetStatusCode()
 	requestField.ClientID = rw.GetClientID()
@@ -94,6 +107,12 @@ func populateResponseHeaderFields(rw *ResponseWriter, requestField *httpRequest)
 	return fields
 }
 
+// FormatLatency formats d in seconds as expected by the httpRequest log field,
+// e.g. "0.0123s".
+func FormatLatency(d time.Duration) string {
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.4f", d.Seconds()), "0"), ".") + "s"
+}
+
 func generateHeaderLogFields(prefix string, headers map[string][]string) []zap.Field {
 	var fields []zap.Field
 	for hdr, values := range headers {
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"net"
 	"net/http"
@@ -14,6 +15,10 @@ type ResponseWriter struct {
 	requestDuration time.Duration
 	clientID        string
 	body            []byte
+	captured        *bytes.Buffer
+	wroteHeader     bool
+	streaming       bool
+	streamEvents    int
 	err             error
 }
 
@@ -22,12 +27,17 @@ func (r *ResponseWriter) Header() http.Header {
 }
 
 func (r *ResponseWriter) Write(body []byte) (int, error) {
+	r.wroteHeader = true
 	r.body = body
+	if r.captured != nil {
+		r.captured.Write(body)
+	}
 	return r.writer.Write(body)
 }
 
 func (r *ResponseWriter) WriteHeader(statusCode int) {
 	r.statusCode = statusCode
+	r.wroteHeader = true
 	r.writer.WriteHeader(statusCode)
 }
 
@@ -39,6 +49,13 @@ func (r *ResponseWriter) WriteClientID(clientID string) {
 	r.clientID = clientID
 }
 
+// WriteStreamEvents marks the response as a stream and records the number of
+// events sent, which LogMiddleware logs when the stream closes.
+func (r *ResponseWriter) WriteStreamEvents(count int) {
+	r.streaming = true
+	r.streamEvents = count
+}
+
 func (r *ResponseWriter) WriteError(err error) {
 	r.err = err
 }
@@ -55,10 +72,37 @@ func (r *ResponseWriter) GetClientID() string {
 	return r.clientID
 }
 
+// HeaderWritten reports whether the response header has been sent.
+func (r *ResponseWriter) HeaderWritten() bool {
+	return r.wroteHeader
+}
+
 func (r *ResponseWriter) GetBody() []byte {
 	return r.body
 }
 
+// CaptureBody makes the writer keep every chunk written afterward, instead of
+// only the last one returned by GetBody.
+func (r *ResponseWriter) CaptureBody() {
+	if r.captured == nil {
+		r.captured = &bytes.Buffer{}
+	}
+}
+
+// GetCapturedBody returns the body written since CaptureBody was called.
+func (r *ResponseWriter) GetCapturedBody() []byte {
+	if r.captured == nil {
+		return nil
+	}
+	return r.captured.Bytes()
+}
+
+// GetStreamEvents returns the number of events sent and whether the response
+// is a stream.
+func (r *ResponseWriter) GetStreamEvents() (int, bool) {
+	return r.streamEvents, r.streaming
+}
+
 func (r *ResponseWriter) GetError() error {
 	return r.err
 }
@@ -71,6 +115,23 @@ func (r *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return h.Hijack()
 }
 
+// Flush implements http.Flusher, so streaming handlers work behind
+// ResponseMiddleware.
+func (r *ResponseWriter) Flush() {
+	if f, ok := r.writer.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Push implements http.Pusher, which is supported by HTTP/2 connections.
+func (r *ResponseWriter) Push(target string, opts *http.PushOptions) error {
+	p, ok := r.writer.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
+
 func ResponseMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		next.ServeHTTP(&ResponseWriter{
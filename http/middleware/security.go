@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Genesic/mixednuts/logging"
+	"github.com/gorilla/mux"
+)
+
+// DisableHeader can be assigned to a header field of SecurityConfig to omit
+// the header instead of using the default.
+const DisableHeader = "-"
+
+const (
+	defaultHSTSMaxAge            = 365 * 24 * time.Hour
+	defaultContentSecurityPolicy = "default-src 'none'; frame-ancestors 'none'"
+	defaultFrameOptions          = "DENY"
+	defaultReferrerPolicy        = "no-referrer"
+	defaultMaxBodyBytes          = 10 << 20
+	defaultMaxHeaderCount        = 100
+	defaultMaxHeaderSize         = 8 << 10
+)
+
+// SecurityConfig configures SecurityMiddleware. Zero values use the defaults.
+type SecurityConfig struct {
+	// HSTSMaxAge of Strict-Transport-Security. Defaults to one year, negative
+	// disables the header.
+	HSTSMaxAge            time.Duration
+	HSTSExcludeSubdomains bool
+	HSTSPreload           bool
+
+	// The following headers accept DisableHeader to omit them.
+	// ContentSecurityPolicy defaults to "default-src 'none'; frame-ancestors 'none'",
+	// which suits JSON APIs.
+	ContentSecurityPolicy string
+	// FrameOptions of X-Frame-Options. Defaults to "DENY".
+	FrameOptions string
+	// ReferrerPolicy defaults to "no-referrer".
+	ReferrerPolicy string
+	// DisableContentTypeNosniff omits "X-Content-Type-Options: nosniff".
+	DisableContentTypeNosniff bool
+
+	// MaxBodyBytes limits the request body. Defaults to 10MB, negative disables
+	// the limit.
+	MaxBodyBytes int64
+	// MaxHeaderCount limits the number of header values. Defaults to 100,
+	// negative disables the limit.
+	MaxHeaderCount int
+	// MaxHeaderSize limits the size of a single header, name and values
+	// included. Defaults to 8KB, negative disables the limit. The size of all
+	// headers is limited by Config.MaxHeaderBytes of the server.
+	MaxHeaderSize int
+}
+
+func (c *SecurityConfig) withDefaults() SecurityConfig {
+	cfg := *c
+	if cfg.HSTSMaxAge == 0 {
+		cfg.HSTSMaxAge = defaultHSTSMaxAge
+	}
+	if cfg.ContentSecurityPolicy == "" {
+		cfg.ContentSecurityPolicy = defaultContentSecurityPolicy
+	}
+	if cfg.FrameOptions == "" {
+		cfg.FrameOptions = defaultFrameOptions
+	}
+	if cfg.ReferrerPolicy == "" {
+		cfg.ReferrerPolicy = defaultReferrerPolicy
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = defaultMaxBodyBytes
+	}
+	if cfg.MaxHeaderCount == 0 {
+		cfg.MaxHeaderCount = defaultMaxHeaderCount
+	}
+	if cfg.MaxHeaderSize == 0 {
+		cfg.MaxHeaderSize = defaultMaxHeaderSize
+	}
+	return cfg
+}
+
+func (c *SecurityConfig) headers() http.Header {
+	header := http.Header{}
+	if c.HSTSMaxAge > 0 {
+		hsts := "max-age=" + strconv.FormatInt(int64(c.HSTSMaxAge.Seconds()), 10)
+		if !c.HSTSExcludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if c.HSTSPreload {
+			hsts += "; preload"
+		}
+		header.Set("Strict-Transport-Security", hsts)
+	}
+	setUnlessDisabled(header, "Content-Security-Policy", c.ContentSecurityPolicy)
+	setUnlessDisabled(header, "X-Frame-Options", c.FrameOptions)
+	setUnlessDisabled(header, "Referrer-Policy", c.ReferrerPolicy)
+	if !c.DisableContentTypeNosniff {
+		header.Set("X-Content-Type-Options", "nosniff")
+	}
+	return header
+}
+
+func setUnlessDisabled(header http.Header, key, value string) {
+	if value != DisableHeader {
+		header.Set(key, value)
+	}
+}
+
+// SecurityMiddleware sets security response headers and rejects oversized
+// requests: 431 when the header limits are exceeded, and 413 when the body is
+// larger than MaxBodyBytes. Bodies without Content-Length are limited with
+// http.MaxBytesReader, in which case the handler gets a *http.MaxBytesError
+// while reading and 413 is answered if it didn't write a response.
+//
+// ResponseMiddleware should be placed before this middleware.
+func SecurityMiddleware(cfg SecurityConfig) mux.MiddlewareFunc {
+	cfg = cfg.withDefaults()
+	securityHeaders := cfg.headers()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw, ok := w.(*ResponseWriter)
+			if !ok {
+				logging.FromContext(r.Context()).Fatalw("ResponseMiddleware should be placed before SecurityMiddleware")
+			}
+
+			header := rw.Header()
+			for k, v := range securityHeaders {
+				header[k] = v
+			}
+
+			if err := checkHeaderLimits(r.Header, cfg.MaxHeaderCount, cfg.MaxHeaderSize); err != nil {
+				writeErrorResponse(rw, http.StatusRequestHeaderFieldsTooLarge, err)
+				return
+			}
+
+			if cfg.MaxBodyBytes < 0 || r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			if r.ContentLength > cfg.MaxBodyBytes {
+				writeErrorResponse(rw, http.StatusRequestEntityTooLarge, &http.MaxBytesError{Limit: cfg.MaxBodyBytes})
+				return
+			}
+
+			body := &maxBytesBody{ReadCloser: http.MaxBytesReader(rw, r.Body, cfg.MaxBodyBytes)}
+			r.Body = body
+			next.ServeHTTP(rw, r)
+
+			if body.err != nil && !rw.HeaderWritten() {
+				writeErrorResponse(rw, http.StatusRequestEntityTooLarge, body.err)
+			} else if body.err != nil && rw.GetError() == nil {
+				rw.WriteError(body.err)
+			}
+		})
+	}
+}
+
+// maxBytesBody records the error of http.MaxBytesReader.
+type maxBytesBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *maxBytesBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		b.err = err
+	}
+	return n, err
+}
+
+func checkHeaderLimits(header http.Header, maxCount, maxSize int) error {
+	var count int
+	for key, values := range header {
+		count += len(values)
+		if maxCount > 0 && count > maxCount {
+			return fmt.Errorf("request has more than %d headers", maxCount)
+		}
+		if maxSize > 0 && len(key)+len(strings.Join(values, ", ")) > maxSize {
+			return fmt.Errorf("request header %s is larger than %d bytes", key, maxSize)
+		}
+	}
+	return nil
+}
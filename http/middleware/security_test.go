@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSecurityMiddleware(t *testing.T) {
+	Convey("test security middleware", t, func() {
+		var calls int
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if _, err := io.ReadAll(r.Body); err != nil && r.URL.Path == "/handled" {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		})
+		serve := func(cfg SecurityConfig, r *http.Request) (*httptest.ResponseRecorder, error) {
+			var rwErr error
+			capture := func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					next.ServeHTTP(w, r)
+					rwErr = w.(*ResponseWriter).GetError()
+				})
+			}
+			w := httptest.NewRecorder()
+			ResponseMiddleware(capture(SecurityMiddleware(cfg)(handler))).ServeHTTP(w, r)
+			return w, rwErr
+		}
+
+		Convey("set default security headers", func() {
+			w, _ := serve(SecurityConfig{}, httptest.NewRequest(http.MethodGet, "/", nil))
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Header().Get("Strict-Transport-Security"), ShouldEqual, "max-age=31536000; includeSubDomains")
+			So(w.Header().Get("Content-Security-Policy"), ShouldEqual, defaultContentSecurityPolicy)
+			So(w.Header().Get("X-Content-Type-Options"), ShouldEqual, "nosniff")
+			So(w.Header().Get("X-Frame-Options"), ShouldEqual, "DENY")
+			So(w.Header().Get("Referrer-Policy"), ShouldEqual, "no-referrer")
+		})
+
+		Convey("override and disable headers", func() {
+			w, _ := serve(SecurityConfig{
+				HSTSMaxAge:            -1,
+				ContentSecurityPolicy: DisableHeader,
+				FrameOptions:          "SAMEORIGIN",
+			}, httptest.NewRequest(http.MethodGet, "/", nil))
+			So(w.Header().Get("Strict-Transport-Security"), ShouldBeEmpty)
+			So(w.Header().Get("Content-Security-Policy"), ShouldBeEmpty)
+			So(w.Header().Get("X-Frame-Options"), ShouldEqual, "SAMEORIGIN")
+		})
+
+		Convey("reject bodies over the limit", func() {
+			cfg := SecurityConfig{MaxBodyBytes: 8}
+
+			w, err := serve(cfg, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("0123456789")))
+			So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(err, ShouldNotBeNil)
+			So(calls, ShouldEqual, 0)
+
+			// Without content length, the limit is enforced while reading.
+			r := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader("0123456789")))
+			r.ContentLength = -1
+			w, err = serve(cfg, r)
+			So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(err, ShouldNotBeNil)
+
+			r = httptest.NewRequest(http.MethodPost, "/handled", io.NopCloser(strings.NewReader("0123456789")))
+			r.ContentLength = -1
+			w, err = serve(cfg, r)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("reject too many or too large headers", func() {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for i := 0; i < 3; i++ {
+				r.Header.Add("X-Many", "v")
+			}
+			w, _ := serve(SecurityConfig{MaxHeaderCount: 2}, r)
+			So(w.Code, ShouldEqual, http.StatusRequestHeaderFieldsTooLarge)
+
+			r = httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-Large", strings.Repeat("a", 64))
+			w, _ = serve(SecurityConfig{MaxHeaderSize: 32}, r)
+			So(w.Code, ShouldEqual, http.StatusRequestHeaderFieldsTooLarge)
+			So(calls, ShouldEqual, 0)
+		})
+	})
+}
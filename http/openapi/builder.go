@@ -0,0 +1,294 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Route describes the operation served by a route registered on the router.
+// Request and Response are values of the Go types exchanged by the handler,
+// e.g. CreateUserRequest{}. Request fields are documented following the
+// binding package: fields tagged with `path`, `query` or `header` become
+// parameters, the others form the body, and `validate` tags are reflected into
+// the schema.
+type Route struct {
+	Method      string
+	Path        string
+	OperationID string
+	Summary     string
+	Description string
+	Tags        []string
+	Request     interface{}
+	Response    interface{}
+	// Status is the status of a successful response. Defaults to 200.
+	Status     int
+	Errors     []ErrorResponse
+	Security   []SecurityRequirement
+	Deprecated bool
+}
+
+type ErrorResponse struct {
+	Status      int
+	Description string
+	Body        interface{}
+}
+
+type Config struct {
+	Info            Info
+	Servers         []Server
+	SecuritySchemes map[string]*SecurityScheme
+	// Security is the default security requirement of all operations.
+	Security []SecurityRequirement
+}
+
+// Build walks router and documents every route with a handler. Routes without
+// method matchers are documented as GET. routes provides additional metadata,
+// matched by method and path template.
+func Build(router *mux.Router, cfg Config, routes []Route) (*Document, error) {
+	doc := &Document{
+		OpenAPI:  Version,
+		Info:     cfg.Info,
+		Servers:  cfg.Servers,
+		Paths:    make(map[string]*PathItem),
+		Security: cfg.Security,
+	}
+
+	meta := make(map[string]Route, len(routes))
+	for _, route := range routes {
+		path, _ := parsePathTemplate(route.Path)
+		meta[strings.ToUpper(route.Method)+" "+path] = route
+	}
+
+	ref := newReflector()
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{http.MethodGet}
+		}
+
+		path, pathParams := parsePathTemplate(tpl)
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+		for _, method := range methods {
+			method = strings.ToUpper(method)
+			r, ok := meta[method+" "+path]
+			if !ok {
+				r = Route{Method: method, Path: path}
+			}
+			(*item)[strings.ToLower(method)] = ref.operation(method, path, pathParams, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ref.schemas) > 0 || len(cfg.SecuritySchemes) > 0 {
+		doc.Components = &Components{SecuritySchemes: cfg.SecuritySchemes}
+		if len(ref.schemas) > 0 {
+			doc.Components.Schemas = ref.schemas
+		}
+	}
+	return doc, nil
+}
+
+func (r *reflector) operation(method, path string, pathParams []string, route Route) *Operation {
+	op := &Operation{
+		OperationID: route.OperationID,
+		Summary:     route.Summary,
+		Description: route.Description,
+		Tags:        route.Tags,
+		Security:    route.Security,
+		Deprecated:  route.Deprecated,
+		Responses:   make(map[string]*Response),
+	}
+	if op.OperationID == "" {
+		op.OperationID = operationID(method, path)
+	}
+
+	documented := make(map[string]struct{})
+	if route.Request != nil {
+		t := indirect(reflect.TypeOf(route.Request))
+		op.Parameters = r.parameters(t)
+		for _, p := range op.Parameters {
+			if p.In == "path" {
+				documented[p.Name] = struct{}{}
+			}
+		}
+		op.RequestBody = r.requestBody(t)
+	}
+
+	var params []*Parameter
+	for _, name := range pathParams {
+		if _, ok := documented[name]; !ok {
+			params = append(params, &Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+	}
+	op.Parameters = append(params, op.Parameters...)
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	op.Responses[strconv.Itoa(status)] = r.response(status, "", route.Response)
+	for _, e := range route.Errors {
+		op.Responses[strconv.Itoa(e.Status)] = r.response(e.Status, e.Description, e.Body)
+	}
+	return op
+}
+
+func (r *reflector) parameters(t reflect.Type) []*Parameter {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var params []*Parameter
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		if sf.Anonymous && indirect(sf.Type).Kind() == reflect.Struct {
+			params = append(params, r.parameters(indirect(sf.Type))...)
+			continue
+		}
+		for _, in := range parameterSourceTags {
+			if _, ok := sf.Tag.Lookup(in); !ok {
+				continue
+			}
+			name, _ := fieldTag(sf, in)
+			schema := r.schemaFor(sf.Type)
+			required := applyValidation(schema, sf)
+			params = append(params, &Parameter{
+				Name:        name,
+				In:          in,
+				Description: sf.Tag.Get("description"),
+				Required:    required || in == "path",
+				Schema:      schema,
+			})
+		}
+	}
+	return params
+}
+
+func (r *reflector) requestBody(t reflect.Type) *RequestBody {
+	if t.Kind() != reflect.Struct {
+		return &RequestBody{
+			Required: true,
+			Content:  map[string]*MediaType{"application/json": {Schema: r.schemaFor(t)}},
+		}
+	}
+
+	hasForm, hasFile, hasBody := false, false, false
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() || isParameter(sf) || sf.Tag.Get("json") == "-" {
+			continue
+		}
+		hasBody = true
+		if _, ok := sf.Tag.Lookup("form"); ok {
+			hasForm = true
+			if indirect(sf.Type) == fileHeaderType || (sf.Type.Kind() == reflect.Slice && indirect(sf.Type.Elem()) == fileHeaderType) {
+				hasFile = true
+			}
+		}
+	}
+	if !hasBody {
+		return nil
+	}
+
+	if hasFile {
+		return &RequestBody{
+			Required: true,
+			Content:  map[string]*MediaType{"multipart/form-data": {Schema: r.structSchema(t, "form")}},
+		}
+	}
+	content := map[string]*MediaType{"application/json": {Schema: r.schemaFor(t)}}
+	if hasForm {
+		content["application/x-www-form-urlencoded"] = &MediaType{Schema: r.structSchema(t, "form")}
+	}
+	return &RequestBody{Required: true, Content: content}
+}
+
+func (r *reflector) response(status int, description string, body interface{}) *Response {
+	if description == "" {
+		description = http.StatusText(status)
+	}
+	resp := &Response{Description: description}
+	if body != nil {
+		resp.Content = map[string]*MediaType{
+			"application/json": {Schema: r.schemaFor(reflect.TypeOf(body))},
+		}
+	}
+	return resp
+}
+
+// parsePathTemplate converts a mux path template into an OpenAPI path by
+// removing variable patterns, e.g. "/users/{id:[0-9]+}" becomes "/users/{id}",
+// and returns the variable names.
+func parsePathTemplate(tpl string) (string, []string) {
+	var (
+		b     strings.Builder
+		names []string
+		depth int
+		name  strings.Builder
+		inPat bool
+	)
+	for _, c := range tpl {
+		switch {
+		case depth == 0 && c == '{':
+			depth = 1
+			inPat = false
+			name.Reset()
+		case depth > 0 && c == '{':
+			depth++
+		case depth > 0 && c == '}':
+			depth--
+			if depth == 0 {
+				names = append(names, name.String())
+				b.WriteString("{" + name.String() + "}")
+			}
+		case depth > 0:
+			if c == ':' && depth == 1 {
+				inPat = true
+			} else if !inPat {
+				name.WriteRune(c)
+			}
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String(), names
+}
+
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, segment := range strings.FieldsFunc(path, func(c rune) bool {
+		return c == '/' || c == '{' || c == '}' || c == '-' || c == '_' || c == '.'
+	}) {
+		b.WriteString(strings.ToUpper(segment[:1]) + segment[1:])
+	}
+	return b.String()
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,125 @@
+package openapi
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+type createUserRequest struct {
+	DryRun bool     `query:"dry-run" description:"validate only"`
+	Name   string   `json:"name" validate:"required,min=1,max=32"`
+	Role   string   `json:"role" validate:"enum=admin|member"`
+	Tags   []string `json:"tags,omitempty" validate:"max=5"`
+}
+
+type getUserRequest struct {
+	ID int64 `path:"id" validate:"min=1"`
+}
+
+type user struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Manager   *user     `json:"manager,omitempty"`
+}
+
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func TestBuild(t *testing.T) {
+	Convey("test openapi document of a small controller", t, func() {
+		noop := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+		router := mux.NewRouter()
+		router.Methods(http.MethodPost).Path("/users").Handler(noop)
+		router.Methods(http.MethodGet).Path("/users/{id:[0-9]+}").Handler(noop)
+		router.Path("/health").Handler(noop)
+
+		doc, err := Build(router, Config{
+			Info: Info{Title: "users", Version: "1.0.0"},
+			SecuritySchemes: map[string]*SecurityScheme{
+				"bearer": {Type: "http", Scheme: "bearer"},
+			},
+			Security: []SecurityRequirement{{"bearer": {}}},
+		}, []Route{
+			{
+				Method:   http.MethodPost,
+				Path:     "/users",
+				Summary:  "create a user",
+				Tags:     []string{"users"},
+				Request:  createUserRequest{},
+				Response: user{},
+				Status:   http.StatusCreated,
+				Errors:   []ErrorResponse{{Status: http.StatusBadRequest, Body: apiError{}}},
+			},
+			{
+				Method:   http.MethodGet,
+				Path:     "/users/{id}",
+				Request:  getUserRequest{},
+				Response: user{},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		bs, err := doc.JSON()
+		So(err, ShouldBeNil)
+		golden := filepath.Join("testdata", "users.golden.json")
+		if *update {
+			So(os.WriteFile(golden, append(bs, '\n'), 0o644), ShouldBeNil)
+		}
+		expected, err := os.ReadFile(golden)
+		So(err, ShouldBeNil)
+		So(string(bs)+"\n", ShouldEqual, string(expected))
+	})
+
+	Convey("omit components without schemas and security schemes", t, func() {
+		router := mux.NewRouter()
+		router.Path("/ping").Handler(http.NotFoundHandler())
+		doc, err := Build(router, Config{}, nil)
+		So(err, ShouldBeNil)
+		So(doc.Components, ShouldBeNil)
+		bs, _ := doc.JSON()
+		So(string(bs), ShouldNotContainSubstring, "components")
+	})
+}
+
+func TestSchema(t *testing.T) {
+	Convey("test schema reflection", t, func() {
+		Convey("parse mux path templates", func() {
+			path, names := parsePathTemplate("/users/{id:[0-9]{1,3}}/posts/{slug}")
+			So(path, ShouldEqual, "/users/{id}/posts/{slug}")
+			So(names, ShouldResemble, []string{"id", "slug"})
+			So(operationID(http.MethodGet, path), ShouldEqual, "getUsersIdPostsSlug")
+		})
+
+		Convey("reflect validation tags", func() {
+			ref := newReflector()
+			schema := ref.structSchema(reflect.TypeOf(createUserRequest{}), "json")
+			So(schema.Required, ShouldResemble, []string{"name"})
+			So(*schema.Properties["name"].MaxLength, ShouldEqual, 32)
+			So(schema.Properties["role"].Enum, ShouldResemble, []interface{}{"admin", "member"})
+			So(*schema.Properties["tags"].MaxItems, ShouldEqual, 5)
+			So(schema.Properties, ShouldNotContainKey, "dry-run")
+		})
+
+		Convey("reference named structs, recursive ones included", func() {
+			ref := newReflector()
+			schema := ref.schemaFor(reflect.TypeOf(&user{}))
+			So(schema.Ref, ShouldEqual, "#/components/schemas/user")
+			So(ref.schemas["user"].Properties["manager"].Ref, ShouldEqual, "#/components/schemas/user")
+			So(ref.schemas["user"].Properties["created_at"].Format, ShouldEqual, "date-time")
+		})
+	})
+}
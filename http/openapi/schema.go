@@ -0,0 +1,256 @@
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"mime/multipart"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	rawMessageType      = reflect.TypeOf(json.RawMessage{})
+	fileHeaderType      = reflect.TypeOf(multipart.FileHeader{})
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonMarshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	parameterSourceTags = []string{"path", "query", "header"}
+)
+
+// reflector converts Go types into schemas. Named struct types are stored in
+// the components and referenced.
+type reflector struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func newReflector() *reflector {
+	return &reflector{
+		schemas: make(map[string]*Schema),
+		names:   make(map[reflect.Type]string),
+	}
+}
+
+func (r *reflector) schemaFor(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &Schema{}
+	case fileHeaderType:
+		return &Schema{Type: "string", Format: "binary"}
+	}
+	if t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType) {
+		return &Schema{}
+	}
+	if t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint, reflect.Uint64:
+		zero := float64(0)
+		return &Schema{Type: "integer", Minimum: &zero}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: r.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: r.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return r.structSchema(t, "json")
+		}
+		return &Schema{Ref: "#/components/schemas/" + r.register(t)}
+	}
+
+	// interface{} and other dynamic types
+	return &Schema{}
+}
+
+// register stores the schema of the named struct t and returns its component
+// name. Types with the same name in different packages are prefixed with the
+// package name.
+func (r *reflector) register(t reflect.Type) string {
+	if name, ok := r.names[t]; ok {
+		return name
+	}
+
+	name := t.Name()
+	if _, taken := r.schemas[name]; taken {
+		pkg := t.PkgPath()
+		name = pkg[strings.LastIndex(pkg, "/")+1:] + "." + name
+	}
+	r.names[t] = name
+	// Placeholder for recursive types.
+	r.schemas[name] = &Schema{}
+	*r.schemas[name] = *r.structSchema(t, "json")
+	return name
+}
+
+// structSchema builds an object schema from the fields of t named by tag.
+// Fields which are bound from query, header or path are skipped.
+func (r *reflector) structSchema(t reflect.Type, tag string) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	r.addFields(schema, t, tag)
+	return schema
+}
+
+func (r *reflector) addFields(schema *Schema, t reflect.Type, tag string) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() || isParameter(sf) {
+			continue
+		}
+
+		name, ok := fieldTag(sf, tag)
+		if !ok {
+			continue
+		}
+
+		ft := sf.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && ft.Kind() == reflect.Struct && sf.Tag.Get(tag) == "" {
+			r.addFields(schema, ft, tag)
+			continue
+		}
+
+		fieldSchema := r.schemaFor(sf.Type)
+		required := applyValidation(fieldSchema, sf)
+		if desc := sf.Tag.Get("description"); desc != "" && fieldSchema.Ref == "" {
+			fieldSchema.Description = desc
+		}
+		schema.Properties[name] = fieldSchema
+		if required {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// fieldTag returns the name of the field in the given tag, ignoring options
+// such as ",omitempty".
+func fieldTag(sf reflect.StructField, tag string) (string, bool) {
+	value := sf.Tag.Get(tag)
+	if value == "-" {
+		return "", false
+	}
+	name := strings.Split(value, ",")[0]
+	if name == "" {
+		name = sf.Name
+	}
+	return name, true
+}
+
+// isParameter reports whether the field is bound from the query, headers or
+// path by the binding package, instead of the body.
+func isParameter(sf reflect.StructField) bool {
+	for _, tag := range parameterSourceTags {
+		if _, ok := sf.Tag.Lookup(tag); ok {
+			_, hasJSON := sf.Tag.Lookup("json")
+			_, hasForm := sf.Tag.Lookup("form")
+			return !hasJSON && !hasForm
+		}
+	}
+	return false
+}
+
+// applyValidation reflects the `validate` tag of the binding package into the
+// schema, and returns whether the field is required.
+func applyValidation(schema *Schema, sf reflect.StructField) bool {
+	tag := sf.Tag.Get("validate")
+	required := false
+	for tag != "" {
+		var part string
+		if strings.HasPrefix(tag, "regex=") {
+			part, tag = tag, ""
+		} else if idx := strings.Index(tag, ","); idx >= 0 {
+			part, tag = tag[:idx], tag[idx+1:]
+		} else {
+			part, tag = tag, ""
+		}
+
+		name, param, _ := strings.Cut(part, "=")
+		switch name {
+		case "required":
+			required = true
+		case "min", "max":
+			applyBound(schema, name, param)
+		case "regex":
+			schema.Pattern = param
+		case "enum":
+			for _, v := range strings.Split(param, "|") {
+				schema.Enum = append(schema.Enum, enumValue(schema.Type, v))
+			}
+		}
+	}
+	return required
+}
+
+func applyBound(schema *Schema, name, param string) {
+	f, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return
+	}
+	n := uint64(f)
+	isMin := name == "min"
+
+	switch schema.Type {
+	case "integer", "number":
+		if isMin {
+			schema.Minimum = &f
+		} else {
+			schema.Maximum = &f
+		}
+	case "string":
+		if isMin {
+			schema.MinLength = &n
+		} else {
+			schema.MaxLength = &n
+		}
+	case "array":
+		if isMin {
+			schema.MinItems = &n
+		} else {
+			schema.MaxItems = &n
+		}
+	}
+}
+
+func enumValue(schemaType, v string) interface{} {
+	switch schemaType {
+	case "integer":
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	case "number":
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return v
+}
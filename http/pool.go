@@ -0,0 +1,333 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Genesic/mixednuts/logging"
+)
+
+const (
+	defaultPoolFailureThreshold = 3
+	defaultPoolCooldown         = 30 * time.Second
+)
+
+// ErrNoEndpoints is returned when the endpoint pool is empty.
+var ErrNoEndpoints = errors.New("no endpoints available")
+
+// Balancer selects the endpoint of each request.
+type Balancer int
+
+const (
+	RoundRobin Balancer = iota
+	Random
+	// LeastInFlight selects the endpoint with the fewest requests whose
+	// response body isn't closed yet.
+	LeastInFlight
+)
+
+// PoolConfig configures an EndpointPool.
+type PoolConfig struct {
+	Balancer Balancer
+	// FailureThreshold is the number of consecutive failures which ejects an
+	// endpoint for Cooldown. Default to 3 and 30s.
+	FailureThreshold int
+	Cooldown         time.Duration
+	// IsFailure reports whether an attempt counts as a failure. By default
+	// transport errors and 5xx responses are failures.
+	IsFailure func(*http.Response, error) bool
+}
+
+// EndpointPool spreads the requests of a Client over several endpoints, for
+// services without load balancer. Endpoints failing repeatedly are ejected
+// for a cooldown; if all of them are ejected, they are all used again rather
+// than failing every request. It's safe for concurrent use.
+type EndpointPool struct {
+	cfg PoolConfig
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int
+	rand      *rand.Rand
+}
+
+type endpoint struct {
+	url          *url.URL
+	inFlight     int
+	failures     int
+	ejectedUntil time.Time
+}
+
+// NewEndpointPool returns a pool of endpoints such as "http://10.0.0.1:8080".
+// Endpoints without scheme use http.
+func NewEndpointPool(endpoints []string, cfg PoolConfig) (*EndpointPool, error) {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = defaultPoolFailureThreshold
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = defaultPoolCooldown
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = func(res *http.Response, err error) bool {
+			return err != nil || res.StatusCode >= http.StatusInternalServerError
+		}
+	}
+
+	p := &EndpointPool{
+		cfg:  cfg,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if err := p.Update(endpoints); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Update replaces the endpoints of the pool. Endpoints already in the pool
+// keep their health and in-flight requests.
+func (p *EndpointPool) Update(endpoints []string) error {
+	urls := make([]*url.URL, 0, len(endpoints))
+	for _, raw := range endpoints {
+		if !strings.HasPrefix(raw, "http://") && !strings.HasPrefix(raw, "https://") {
+			raw = "http://" + raw
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid endpoint %q", raw)
+		}
+		urls = append(urls, &url.URL{Scheme: u.Scheme, Host: u.Host})
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	existing := make(map[string]*endpoint, len(p.endpoints))
+	for _, e := range p.endpoints {
+		existing[e.url.String()] = e
+	}
+	p.endpoints = make([]*endpoint, 0, len(urls))
+	for _, u := range urls {
+		if e, ok := existing[u.String()]; ok {
+			p.endpoints = append(p.endpoints, e)
+			delete(existing, u.String())
+			continue
+		}
+		p.endpoints = append(p.endpoints, &endpoint{url: u})
+	}
+	return nil
+}
+
+// Endpoints returns the endpoints of the pool.
+func (p *EndpointPool) Endpoints() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	endpoints := make([]string, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		endpoints = append(endpoints, e.url.String())
+	}
+	return endpoints
+}
+
+// Resolver returns the current endpoints of a service.
+type Resolver func(ctx context.Context) ([]string, error)
+
+// StaticResolver always returns endpoints.
+func StaticResolver(endpoints ...string) Resolver {
+	return func(context.Context) ([]string, error) {
+		return endpoints, nil
+	}
+}
+
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// SRVResolver returns the targets of the DNS SRV records of
+// _service._proto.name as endpoints with scheme. Only the records of the best
+// (lowest) priority are returned, so backup targets are only used once the
+// records of the primary ones are removed. The pool balances equally over
+// the targets, ignoring their weight.
+// See: https://www.rfc-editor.org/rfc/rfc2782
+func SRVResolver(scheme, service, proto, name string) Resolver {
+	return func(ctx context.Context) ([]string, error) {
+		_, records, err := lookupSRV(ctx, service, proto, name)
+		if err != nil {
+			return nil, err
+		}
+		var endpoints []string
+		for _, srv := range bestPriority(records) {
+			host := strings.TrimSuffix(srv.Target, ".")
+			endpoints = append(endpoints, scheme+"://"+net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+		}
+		return endpoints, nil
+	}
+}
+
+// bestPriority returns the records with the lowest priority, in order.
+func bestPriority(records []*net.SRV) []*net.SRV {
+	var best []*net.SRV
+	for _, srv := range records {
+		switch {
+		case len(best) == 0 || srv.Priority < best[0].Priority:
+			best = []*net.SRV{srv}
+		case srv.Priority == best[0].Priority:
+			best = append(best, srv)
+		}
+	}
+	return best
+}
+
+// Watch updates the pool from resolve every interval until ctx is done. An
+// empty result or an error keeps the current endpoints. Run it in its own
+// goroutine.
+func (p *EndpointPool) Watch(ctx context.Context, interval time.Duration, resolve Resolver) {
+	logger := logging.FromContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		endpoints, err := resolve(ctx)
+		switch {
+		case err != nil:
+			logger.Warnw("failed to resolve endpoints", "err", err)
+		case len(endpoints) == 0:
+			logger.Warnw("resolved no endpoints, keeping the current ones")
+		default:
+			if err := p.Update(endpoints); err != nil {
+				logger.Warnw("failed to update endpoints", "err", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pick selects an endpoint, avoiding the ones in tried and ejected ones.
+func (p *EndpointPool) pick(tried map[*endpoint]bool, now time.Time) (*endpoint, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	candidates := p.filter(func(e *endpoint) bool { return !tried[e] && !e.ejected(now) })
+	if len(candidates) == 0 {
+		candidates = p.filter(func(e *endpoint) bool { return !e.ejected(now) })
+	}
+	if len(candidates) == 0 {
+		candidates = p.endpoints
+	}
+
+	var e *endpoint
+	switch p.cfg.Balancer {
+	case Random:
+		e = candidates[p.rand.Intn(len(candidates))]
+	case LeastInFlight:
+		e = candidates[0]
+		for _, c := range candidates[1:] {
+			if c.inFlight < e.inFlight {
+				e = c
+			}
+		}
+	default:
+		e = candidates[p.next%len(candidates)]
+		p.next++
+	}
+	e.inFlight++
+	return e, nil
+}
+
+// hasUntried reports whether a healthy endpoint wasn't tried yet.
+func (p *EndpointPool) hasUntried(tried map[*endpoint]bool, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.filter(func(e *endpoint) bool { return !tried[e] && !e.ejected(now) })) > 0
+}
+
+func (p *EndpointPool) filter(keep func(*endpoint) bool) []*endpoint {
+	var endpoints []*endpoint
+	for _, e := range p.endpoints {
+		if keep(e) {
+			endpoints = append(endpoints, e)
+		}
+	}
+	return endpoints
+}
+
+// done records the result of an attempt sent to e. The request stays in
+// flight until the response body is closed.
+func (p *EndpointPool) done(req *http.Request, e *endpoint, res *http.Response, err error, now time.Time) {
+	failure := p.cfg.IsFailure(res, err)
+
+	p.mu.Lock()
+	switch {
+	case canceled(req, err):
+		// Says nothing about the endpoint, such as a hedge which lost.
+	case !failure:
+		e.failures = 0
+	default:
+		if e.failures++; e.failures >= p.cfg.FailureThreshold {
+			e.failures = 0
+			e.ejectedUntil = now.Add(p.cfg.Cooldown)
+			logging.FromContext(req.Context()).Warnw("endpoint ejected",
+				"endpoint", e.url.String(),
+				"until", e.ejectedUntil)
+		}
+	}
+	if res == nil {
+		e.inFlight--
+	}
+	p.mu.Unlock()
+
+	if res != nil {
+		res.Body = &releaseOnClose{ReadCloser: res.Body, release: func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			e.inFlight--
+		}}
+	}
+}
+
+func (e *endpoint) ejected(now time.Time) bool {
+	return now.Before(e.ejectedUntil)
+}
+
+// route returns a copy of req sent to e, keeping the path of the base URL.
+func (e *endpoint) route(req *http.Request) *http.Request {
+	routed := req.Clone(req.Context())
+	routed.URL.Scheme = e.url.Scheme
+	routed.URL.Host = e.url.Host
+	routed.Host = ""
+	return routed
+}
+
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	r.once.Do(r.release)
+	return r.ReadCloser.Close()
+}
+
+// WithEndpointPool sends requests to the endpoints of pool instead of the
+// host of the base URL, whose path is kept. The attempts of the retry policy
+// fail over to another endpoint, without backoff while an endpoint wasn't
+// tried yet.
+func (c *Client) WithEndpointPool(pool *EndpointPool) *Client {
+	c = c.clone()
+	c.pool = pool
+	return c
+}
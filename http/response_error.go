@@ -0,0 +1,133 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// ResponseError is returned by Client for non-2xx responses. It implements
+// errors.HttpError, so a handler returning it forwards the downstream status
+// and body. Use errors.As to inspect it:
+//
+//	var resErr *ResponseError
+//	if errors.As(err, &resErr) && resErr.StatusCode == http.StatusNotFound {
+//		...
+//	}
+type ResponseError struct {
+	StatusCode int
+	Header     http.Header
+	// Body is the response body with surrounding spaces trimmed.
+	Body []byte
+	// Payload is the JSON body decoded into the value returned by the function
+	// set with Client.WithErrorPayload. It's nil if none is set or the body
+	// can't be decoded.
+	Payload interface{}
+}
+
+// Error returns the response body, as the errors previously returned by
+// Client, or the status text if it's empty.
+func (e *ResponseError) Error() string {
+	if len(e.Body) == 0 {
+		return fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return string(e.Body)
+}
+
+func (e *ResponseError) GetCode() int {
+	return e.StatusCode
+}
+
+// GetMessage returns the body if it's JSON, otherwise the body as the message
+// of a JSON object.
+func (e *ResponseError) GetMessage() string {
+	if json.Valid(e.Body) {
+		return string(e.Body)
+	}
+	bs, _ := json.Marshal(struct {
+		Message string `json:"message"`
+	}{Message: e.Error()})
+	return string(bs)
+}
+
+// Decode unmarshals the JSON body into v.
+func (e *ResponseError) Decode(v interface{}) error {
+	return json.Unmarshal(e.Body, v)
+}
+
+// WithErrorPayload decodes the JSON body of non-2xx responses into the value
+// returned by newPayload, available as ResponseError.Payload.
+//
+//	client.WithErrorPayload(func() interface{} { return &apiError{} })
+func (c *Client) WithErrorPayload(newPayload func() interface{}) *Client {
+	c = c.clone()
+	c.errorPayload = newPayload
+	return c
+}
+
+// newResponseError reads the body of res into a ResponseError, decoding its
+// payload into the value returned by newPayload if not nil.
+func newResponseError(res *http.Response, newPayload func() interface{}) error {
+	bs, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("cannot read service error in response body: %w", err)
+	}
+
+	resErr := &ResponseError{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       bytes.TrimSpace(bs),
+	}
+	if newPayload != nil {
+		payload := newPayload()
+		if resErr.Decode(payload) == nil {
+			resErr.Payload = payload
+		}
+	}
+	return resErr
+}
+
+// IsClientError reports whether err is a ResponseError with a 4xx status.
+func IsClientError(err error) bool {
+	var resErr *ResponseError
+	return errors.As(err, &resErr) && resErr.StatusCode >= 400 && resErr.StatusCode < 500
+}
+
+// IsServerError reports whether err is a ResponseError with a 5xx status.
+func IsServerError(err error) bool {
+	var resErr *ResponseError
+	return errors.As(err, &resErr) && resErr.StatusCode >= 500
+}
+
+// IsTimeout reports whether no response was received in time, because of the
+// Client timeout or the context deadline.
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// IsConnectionError reports whether the connection to the service failed:
+// it couldn't be dialed, was refused or reset, or the host couldn't be
+// resolved. Other network errors, such as a truncated response or a TLS
+// handshake failure, are not connection errors, since the request may have
+// been processed.
+func IsConnectionError(err error) bool {
+	if err == nil || IsTimeout(err) {
+		return false
+	}
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return (errors.As(err, &opErr) && opErr.Op == "dial") ||
+		errors.As(err, &dnsErr) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,194 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Genesic/mixednuts/http/middleware"
+)
+
+const (
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 10 * time.Second
+	// maxDrainBytes is the amount of a discarded response body read so the
+	// connection can be reused.
+	maxDrainBytes = 4 << 10
+)
+
+var defaultRetryableStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy configures how Client retries failed requests. Only idempotent
+// requests are retried: GET, HEAD, OPTIONS, TRACE, PUT and DELETE, or any
+// method with an Idempotency-Key header. The retries are bounded by the
+// request context, and the Client timeout applies to each attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one. Values
+	// lower than 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the upper bound of the first wait. It doubles for each
+	// attempt up to MaxBackoff, and the actual wait is a random duration
+	// between zero and the bound (full jitter). Default to 100ms and 10s.
+	// A response asking with Retry-After to wait longer than MaxBackoff is
+	// returned without retrying.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// RetryableStatusCodes defaults to 429, 500, 502, 503 and 504.
+	RetryableStatusCodes []int
+	// RetryableError reports whether a transport error is retried. By default
+	// every error is retried, including the timeout of an attempt. The request
+	// context being done always stops the retries.
+	RetryableError func(error) bool
+}
+
+// DefaultRetryPolicy makes 3 attempts with the default backoff.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 3}
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if p.RetryableStatusCodes == nil {
+		p.RetryableStatusCodes = defaultRetryableStatusCodes
+	}
+	return p
+}
+
+func (p RetryPolicy) enabled() bool {
+	return p.MaxAttempts > 1
+}
+
+func (p RetryPolicy) retryableStatus(code int) bool {
+	for _, c := range p.RetryableStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (p RetryPolicy) retryableError(err error) bool {
+	if p.RetryableError != nil {
+		return p.RetryableError(err)
+	}
+	// The deadline of the request context is checked by the caller, so a
+	// DeadlineExceeded error here is the timeout of a single attempt.
+	return !errors.Is(err, context.Canceled)
+}
+
+// backoff returns the wait before the given retry, starting from 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	bound := p.InitialBackoff
+	for i := 1; i < retry && bound < p.MaxBackoff; i++ {
+		bound *= 2
+	}
+	if bound > p.MaxBackoff {
+		bound = p.MaxBackoff
+	}
+	return jitter(bound)
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func jitter(bound time.Duration) time.Duration {
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(bound) + 1))
+}
+
+// WithRetryPolicy retries failed requests according to policy.
+func (c *Client) WithRetryPolicy(policy RetryPolicy) *Client {
+	c = c.clone()
+	c.retryPolicy = policy.withDefaults()
+	return c
+}
+
+// isIdempotent reports whether req can be safely sent several times.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get(middleware.IdempotencyKeyHeader) != ""
+}
+
+// canReplay reports whether the body of req can be sent again.
+func canReplay(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// rewind returns a copy of req with a fresh body for another attempt.
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	clone := req.Clone(req.Context())
+	clone.Body = body
+	return clone, nil
+}
+
+// retryAfter parses the Retry-After header, in seconds or as an http date.
+func retryAfter(res *http.Response, now time.Time) (time.Duration, bool) {
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// fitsDeadline reports whether waiting for d leaves time for another attempt
+// before the context deadline.
+func fitsDeadline(ctx context.Context, d time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > d
+}
+
+// sleep waits for d unless ctx is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// discard drains and closes the body of a response which is retried.
+func discard(res *http.Response) {
+	_, _ = io.CopyN(io.Discard, res.Body, maxDrainBytes)
+	_ = res.Body.Close()
+}
@@ -0,0 +1,177 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+const (
+	routeSourceAdditional = "additional"
+	routeSourceBuiltin    = "builtin"
+)
+
+// RouteInfo describes a route served by the MuxServer.
+type RouteInfo struct {
+	Path        string   `json:"path"`
+	Methods     []string `json:"methods,omitempty"`
+	Source      string   `json:"source"`
+	Middlewares []string `json:"middlewares,omitempty"`
+	// ShadowedBy is the path of an earlier route matching the same requests,
+	// in which case this route is never reached.
+	ShadowedBy string `json:"shadowedBy,omitempty"`
+
+	route *mux.Route
+}
+
+// routeSources records which controller registered each route.
+type routeSources map[*mux.Route]string
+
+// record assigns source to every route of router which has no source yet.
+func (rs routeSources) record(router *mux.Router, source string) {
+	_ = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		if _, ok := rs[route]; !ok {
+			rs[route] = source
+		}
+		return nil
+	})
+}
+
+func controllerSource(controller Controller) string {
+	return "controller:" + reflect.TypeOf(controller).String()
+}
+
+// WithRouteTableEndpoint serves the table of registered routes as JSON at
+// path. It's meant for debugging and shouldn't be exposed publicly.
+func (s *MuxServer) WithRouteTableEndpoint(path string) *MuxServer {
+	s.routeTablePath = path
+	return s
+}
+
+// buildRouteTable walks router in matching order and detects routes which are
+// shadowed by an earlier route.
+func buildRouteTable(router *mux.Router, sources routeSources, middlewares []string) []RouteInfo {
+	var table []RouteInfo
+	_ = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+
+		info := RouteInfo{
+			Path:    tpl,
+			Methods: methods,
+			Source:  sources[route],
+			route:   route,
+		}
+		if info.Source == "" {
+			info.Source = routeSourceBuiltin
+		}
+		if strings.HasPrefix(info.Source, "controller:") {
+			info.Middlewares = middlewares
+		}
+		info.ShadowedBy = shadowedBy(table, info)
+		table = append(table, info)
+		return nil
+	})
+	return table
+}
+
+// allMethods are the methods matched by a route without method matcher.
+var allMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+}
+
+// shadowedBy returns the path of the first route in table which matches the
+// requests of info. Templates with variables are only compared literally.
+func shadowedBy(table []RouteInfo, info RouteInfo) string {
+	methods := info.Methods
+	if len(methods) == 0 {
+		methods = allMethods
+	}
+
+	for _, prev := range table {
+		if prev.Path == info.Path && methodsOverlap(prev.Methods, info.Methods) {
+			return prev.Path
+		}
+		if strings.Contains(info.Path, "{") {
+			continue
+		}
+		for _, method := range methods {
+			var match mux.RouteMatch
+			// Built by hand since paths such as "/files/%" aren't valid
+			// request URIs.
+			probe := &http.Request{Method: method, URL: &url.URL{Path: info.Path}, Header: http.Header{}}
+			if prev.route.Match(probe, &match) && match.MatchErr == nil {
+				return prev.Path
+			}
+		}
+	}
+	return ""
+}
+
+func methodsOverlap(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	for _, m := range a {
+		for _, n := range b {
+			if m == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func logRouteTable(logger *zap.SugaredLogger, table []RouteInfo) {
+	for _, info := range table {
+		logger.Infow("route info",
+			"path", info.Path,
+			"methods", info.Methods,
+			"source", info.Source,
+			"middlewares", info.Middlewares)
+		if info.ShadowedBy != "" {
+			logger.Warnw("route is shadowed by an earlier route and will never be reached",
+				"path", info.Path,
+				"methods", info.Methods,
+				"source", info.Source,
+				"shadowedBy", info.ShadowedBy)
+		}
+	}
+}
+
+func routeTableHandler(table *[]RouteInfo) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(*table)
+	})
+}
+
+// middlewareNames returns the short function names of fns, e.g.
+// "middleware.RequestIDMiddleware".
+func middlewareNames(fns []mux.MiddlewareFunc) []string {
+	names := make([]string, 0, len(fns))
+	for _, fn := range fns {
+		name := fmt.Sprintf("%T", fn)
+		if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+			name = f.Name()
+			name = name[strings.LastIndex(name, "/")+1:]
+		}
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,238 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Genesic/mixednuts/http/middleware"
+)
+
+const (
+	LastEventIDHeader = "Last-Event-ID"
+	// lastEventIDParam is used by EventSource polyfills which can't set
+	// headers.
+	lastEventIDParam = "lastEventId"
+
+	defaultHeartbeat = 15 * time.Second
+)
+
+var (
+	// ErrStreamingUnsupported is returned by NewStream when the
+	// http.ResponseWriter can't be flushed.
+	ErrStreamingUnsupported = errors.New("streaming unsupported by the response writer")
+	// ErrClosed is returned by Send after the client disconnected or the
+	// stream was closed.
+	ErrClosed = errors.New("stream closed")
+)
+
+// Event is a server-sent event. Multi-line data is split into several data
+// fields.
+// See: https://html.spec.whatwg.org/multipage/server-sent-events.html
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+type Config struct {
+	// Heartbeat is the interval of the comments sent to keep the connection
+	// alive through proxies. Defaults to 15s, negative disables heartbeats.
+	Heartbeat time.Duration
+	// Retry is sent to the client when the stream starts, if set.
+	Retry time.Duration
+}
+
+// Stream writes server-sent events to a client. It's safe for concurrent use.
+//
+// When the http.ResponseWriter is a *middleware.ResponseWriter, the number of
+// events is recorded so LogMiddleware logs it once the stream closes. Note the
+// server WriteTimeout also applies to streams, so it has to be longer than
+// the expected stream duration.
+type Stream struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	rw      *middleware.ResponseWriter
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lastEventID string
+	events      int
+	closed      bool
+}
+
+// NewStream sends the event stream headers and starts the heartbeats. The
+// stream ends when the request context is done, i.e. the client disconnected,
+// or Close is called. Handlers should return after the stream is done:
+//
+//	stream, err := sse.NewStream(w, r, sse.Config{})
+//	if err != nil {
+//		return err
+//	}
+//	defer stream.Close()
+//	for {
+//		select {
+//		case <-stream.Done():
+//			return nil
+//		case status := <-updates:
+//			if err := stream.Send(sse.Event{Event: "status", Data: status}); err != nil {
+//				return nil
+//			}
+//		}
+//	}
+func NewStream(w http.ResponseWriter, r *http.Request, cfg Config) (*Stream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrStreamingUnsupported
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	s := &Stream{
+		w:           w,
+		flusher:     flusher,
+		ctx:         ctx,
+		cancel:      cancel,
+		lastEventID: r.Header.Get(LastEventIDHeader),
+	}
+	if s.lastEventID == "" {
+		s.lastEventID = r.URL.Query().Get(lastEventIDParam)
+	}
+	if rw, ok := w.(*middleware.ResponseWriter); ok {
+		s.rw = rw
+		rw.WriteStreamEvents(0)
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	// Disables response buffering of nginx.
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	var b strings.Builder
+	if cfg.Retry > 0 {
+		writeRetry(&b, cfg.Retry)
+		b.WriteString("\n")
+	}
+	if err := s.write(b.String()); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	heartbeat := cfg.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = defaultHeartbeat
+	}
+	if heartbeat > 0 {
+		go s.heartbeat(heartbeat)
+	}
+	return s, nil
+}
+
+// LastEventID returns the ID of the last event received by a reconnecting
+// client, so the stream can resume after it. It's empty for new clients.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed when the client disconnects or the stream is closed.
+func (s *Stream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Events returns the number of events sent.
+func (s *Stream) Events() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events
+}
+
+// Send writes an event and flushes it to the client.
+func (s *Stream) Send(e Event) error {
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + sanitize(e.ID) + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + sanitize(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		writeRetry(&b, e.Retry)
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(e.Data, "\r\n", "\n"), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.writeLocked(b.String()); err != nil {
+		return err
+	}
+	s.events++
+	if s.rw != nil {
+		s.rw.WriteStreamEvents(s.events)
+	}
+	return nil
+}
+
+// Close stops the heartbeats. Events can't be sent afterward.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.cancel()
+}
+
+func (s *Stream) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.write(":\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Stream) write(msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeLocked(msg)
+}
+
+func (s *Stream) writeLocked(msg string) error {
+	if s.closed || s.ctx.Err() != nil {
+		return ErrClosed
+	}
+	if msg != "" {
+		if _, err := s.w.Write([]byte(msg)); err != nil {
+			s.closed = true
+			s.cancel()
+			return err
+		}
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func writeRetry(b *strings.Builder, retry time.Duration) {
+	b.WriteString("retry: " + strconv.FormatInt(retry.Milliseconds(), 10) + "\n")
+}
+
+// sanitize removes line breaks which would end the field.
+func sanitize(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -0,0 +1,86 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Genesic/mixednuts/http/middleware"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStream(t *testing.T) {
+	Convey("test server-sent events", t, func() {
+		recorded := make(chan int, 1)
+		sendAfterDone := make(chan error, 1)
+		record := func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r)
+				events, _ := w.(*middleware.ResponseWriter).GetStreamEvents()
+				recorded <- events
+			})
+		}
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			stream, err := NewStream(w, r, Config{Heartbeat: 10 * time.Millisecond, Retry: time.Second})
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			defer stream.Close()
+
+			_ = stream.Send(Event{ID: "1", Event: "status", Data: "resumed after " + stream.LastEventID()})
+			_ = stream.Send(Event{ID: "2", Data: "line one\nline two"})
+			if r.URL.Query().Get("wait") != "" {
+				<-stream.Done()
+				sendAfterDone <- stream.Send(Event{Data: "gone"})
+			}
+		})
+		chain := middleware.ResponseMiddleware(record(middleware.LogMiddleware()(handler)))
+		server := httptest.NewServer(chain)
+		defer server.Close()
+
+		Convey("frame events and record them for logging", func() {
+			req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			req.Header.Set(LastEventIDHeader, "41")
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+
+			var body strings.Builder
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				body.WriteString(scanner.Text() + "\n")
+			}
+			So(body.String(), ShouldStartWith, "retry: 1000\n\n"+
+				"id: 1\nevent: status\ndata: resumed after 41\n\n"+
+				"id: 2\ndata: line one\ndata: line two\n\n")
+			So(<-recorded, ShouldEqual, 2)
+		})
+
+		Convey("detect client disconnection and send heartbeats", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?wait=1", nil)
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+
+			reader := bufio.NewReader(resp.Body)
+			var heartbeat bool
+			for !heartbeat {
+				line, err := reader.ReadString('\n')
+				So(err, ShouldBeNil)
+				heartbeat = line == ":\n"
+			}
+			cancel()
+			_ = resp.Body.Close()
+			So(<-sendAfterDone, ShouldEqual, ErrClosed)
+			So(<-recorded, ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,290 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/Genesic/mixednuts/logging"
+)
+
+// ErrResourceChanged is returned by Download when a resumed download would
+// mix two versions of the resource.
+var ErrResourceChanged = errors.New("resource changed while downloading")
+
+// Part is a part of a multipart/form-data body. Parts with a FileName are
+// files, whose content type defaults to application/octet-stream.
+type Part struct {
+	Name        string
+	FileName    string
+	ContentType string
+	Body        io.Reader
+}
+
+// Field returns a form field part.
+func Field(name, value string) Part {
+	return Part{Name: name, Body: strings.NewReader(value)}
+}
+
+// File returns a file part read from r.
+func File(name, fileName string, r io.Reader) Part {
+	return Part{Name: name, FileName: fileName, Body: r}
+}
+
+func (c *Client) CommonDoWithMultipart(method, path string, headers map[string]string, parts []Part, output interface{}) (int, error) {
+	return c.CommonDoWithMultipartContext(context.Background(), method, path, headers, parts, output)
+}
+
+// CommonDoWithMultipartContext sends parts as a multipart/form-data body and
+// decodes the JSON response into output. The body is streamed while the parts
+// are read, so files aren't loaded into memory; as a consequence the request
+// is never retried. Parts implementing io.Closer are not closed.
+func (c *Client) CommonDoWithMultipartContext(ctx context.Context, method, path string, headers map[string]string, parts []Part, output interface{}) (int, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeParts(mw, parts))
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, pr)
+	if err != nil {
+		_ = pr.Close()
+		return -1, err
+	}
+	c.setHeaders(req, headers)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	statusCode, err := c.CommonDoFromRequest(req, output)
+	// Unblocks the writer if the request failed before reading the body.
+	_ = pr.Close()
+	return statusCode, err
+}
+
+func writeParts(mw *multipart.Writer, parts []Part) error {
+	for _, part := range parts {
+		header := make(textproto.MIMEHeader)
+		disposition := fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(part.Name))
+		if part.FileName != "" {
+			disposition += fmt.Sprintf(`; filename="%s"`, escapeQuotes(part.FileName))
+		}
+		header.Set("Content-Disposition", disposition)
+		contentType := part.ContentType
+		if contentType == "" && part.FileName != "" {
+			contentType = "application/octet-stream"
+		}
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+
+		w, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, part.Body); err != nil {
+			return fmt.Errorf("failed to write part %s: %w", part.Name, err)
+		}
+	}
+	return mw.Close()
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+// Stream sends a request and returns the response body without reading it,
+// for responses too large to be buffered. The caller must close it. Non-2xx
+// responses result in a *ResponseError.
+//
+// The Client timeout includes reading the body, so large responses should be
+// read with a client created with WithTimeout(0) and a context deadline.
+func (c *Client) Stream(ctx context.Context, method, path string, headers map[string]string) (io.ReadCloser, error) {
+	res, err := c.stream(ctx, method, path, headers)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (c *Client) stream(ctx context.Context, method, path string, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.setHeaders(req, headers)
+
+	res, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		return nil, newResponseError(res, c.errorPayload)
+	}
+	return res, nil
+}
+
+// DownloadOptions configures Download.
+type DownloadOptions struct {
+	Headers map[string]string
+	// Offset starts the download at a byte offset with a Range request, e.g.
+	// to complete a partial file. Offset bytes are skipped if the server
+	// ignores the range.
+	Offset int64
+	// MaxResumes is the number of times the download is resumed with a Range
+	// request after the connection fails while reading the body.
+	MaxResumes int
+	// Progress is called after each write with the number of bytes written
+	// including Offset, and the total size or -1 if unknown.
+	Progress func(written, total int64)
+}
+
+// Download writes the body of a GET request to w as it's received and returns
+// the number of bytes written, excluding Offset. Resumed downloads use
+// If-Range, so a resource modified in between results in ErrResourceChanged
+// instead of a corrupted download.
+func (c *Client) Download(ctx context.Context, path string, w io.Writer, opts DownloadOptions) (int64, error) {
+	d := &download{w: w, offset: opts.Offset, total: -1, progress: opts.Progress}
+	var written int64
+	for resumes := 0; ; resumes++ {
+		n, err := c.downloadFrom(ctx, path, d, opts.Headers)
+		written += n
+		if err == nil {
+			return written, nil
+		}
+		var readErr *bodyReadError
+		if resumes >= opts.MaxResumes || ctx.Err() != nil || !errors.As(err, &readErr) {
+			return written, err
+		}
+		logging.FromContext(ctx).Infow("resuming download",
+			"url", c.baseURL+path,
+			"offset", d.offset,
+			"err", readErr.err)
+	}
+}
+
+type download struct {
+	w        io.Writer
+	offset   int64
+	total    int64
+	progress func(written, total int64)
+	// validator is the ETag or Last-Modified of the first response, used to
+	// resume the same version of the resource.
+	validator string
+}
+
+// bodyReadError is a failure while reading the body, which can be resumed.
+type bodyReadError struct {
+	err error
+}
+
+func (e *bodyReadError) Error() string { return e.err.Error() }
+func (e *bodyReadError) Unwrap() error { return e.err }
+
+func (c *Client) downloadFrom(ctx context.Context, path string, d *download, headers map[string]string) (int64, error) {
+	rangeHeaders := make(map[string]string, len(headers)+2)
+	for key, value := range headers {
+		rangeHeaders[key] = value
+	}
+	if d.offset > 0 {
+		rangeHeaders["Range"] = "bytes=" + strconv.FormatInt(d.offset, 10) + "-"
+		if d.validator != "" {
+			rangeHeaders["If-Range"] = d.validator
+		}
+	}
+
+	res, err := c.stream(ctx, http.MethodGet, path, rangeHeaders)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	validator := res.Header.Get("ETag")
+	if validator == "" {
+		validator = res.Header.Get("Last-Modified")
+	}
+	switch {
+	case res.StatusCode == http.StatusPartialContent:
+		contentRange := res.Header.Get("Content-Range")
+		start, total, ok := parseContentRange(contentRange)
+		if !ok || start != d.offset {
+			return 0, fmt.Errorf("unexpected content range %q resuming download at %d", contentRange, d.offset)
+		}
+		if total >= 0 {
+			d.total = total
+		}
+	case d.validator != "" && validator != d.validator:
+		return 0, ErrResourceChanged
+	default:
+		// The range was ignored, skip the bytes already written.
+		if _, err := io.CopyN(io.Discard, res.Body, d.offset); err != nil {
+			return 0, &bodyReadError{err: err}
+		}
+		if res.ContentLength >= 0 {
+			d.total = res.ContentLength
+		}
+	}
+	if d.validator == "" {
+		d.validator = validator
+	}
+
+	var written int64
+	buf := make([]byte, 32<<10)
+	for {
+		n, readErr := res.Body.Read(buf)
+		if n > 0 {
+			if _, err := d.w.Write(buf[:n]); err != nil {
+				return written, err
+			}
+			written += int64(n)
+			d.offset += int64(n)
+			if d.progress != nil {
+				d.progress(d.offset, d.total)
+			}
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, &bodyReadError{err: readErr}
+		}
+	}
+}
+
+// parseContentRange parses the first byte position and the total size of a
+// Content-Range header such as "bytes 100-199/200". The total is -1 when
+// unknown, as in "bytes 100-199/*".
+func parseContentRange(value string) (start, total int64, ok bool) {
+	if !strings.HasPrefix(value, "bytes ") {
+		return 0, 0, false
+	}
+	byteRange, size, found := strings.Cut(strings.TrimPrefix(value, "bytes "), "/")
+	if !found {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(byteRange, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if end, err := strconv.ParseInt(last, 10, 64); err != nil || end < start {
+		return 0, 0, false
+	}
+	if size == "*" {
+		return start, -1, true
+	}
+	if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+		return 0, 0, false
+	}
+	return start, total, true
+}
@@ -0,0 +1,500 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Genesic/mixednuts/logging"
+	"github.com/Genesic/mixednuts/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CacheStatusHeader is set by Cache on the responses of cacheable requests.
+const CacheStatusHeader = "X-Cache"
+
+// Values of CacheStatusHeader.
+const (
+	// CacheHit is a fresh stored response.
+	CacheHit = "HIT"
+	// CacheStale is a stale stored response served while it's revalidated in
+	// the background.
+	CacheStale = "STALE"
+	// CacheRevalidated is a stored response the server confirmed unchanged.
+	CacheRevalidated = "REVALIDATED"
+	// CacheMiss is a response from the server.
+	CacheMiss = "MISS"
+)
+
+const (
+	defaultCacheMaxBodySize       = 1 << 20
+	defaultCacheRevalidateTimeout = 30 * time.Second
+)
+
+// CacheConfig configures the Cache round-tripper.
+type CacheConfig struct {
+	// Store defaults to an LRUStore of 1000 responses.
+	Store CacheStore
+	// MaxBodySize is the size of the largest body stored, default to 1MiB.
+	MaxBodySize int64
+	// RevalidateTimeout bounds the background revalidations of
+	// stale-while-revalidate, default to 30s.
+	RevalidateTimeout time.Duration
+	// Registerer defaults to prometheus.DefaultRegisterer.
+	Registerer prometheus.Registerer
+	// Now returns the time the age of stored responses is computed with,
+	// default to time.Now.
+	Now func() time.Time
+}
+
+type cache struct {
+	cfg      CacheConfig
+	requests *prometheus.CounterVec
+
+	mu           sync.Mutex
+	revalidating map[string]bool
+}
+
+// Cache is a private http cache following RFC 9111. It stores the responses
+// of GET and HEAD requests which have an explicit lifetime (Cache-Control
+// max-age or Expires) or validators (ETag or Last-Modified), serves them while
+// fresh, and revalidates them with conditional requests once stale. With the
+// stale-while-revalidate directive, stale responses are served during the
+// given window while a background request revalidates them, unless the
+// request sets its own max-age. Successful unsafe requests invalidate the
+// stored responses of their URL, and of the Location and Content-Location of
+// their response.
+//
+// Responses are stored by path and query, regardless of the host, so the
+// endpoints of an EndpointPool share them and a write through one endpoint
+// invalidates the reads through the others. A Cache should therefore be used
+// by the clients of a single service.
+//
+// Stored responses are shared by every request of the URL, whatever its
+// credentials, so responses to requests with an Authorization header are only
+// stored when they allow it with public, s-maxage or must-revalidate.
+//
+// Responses of cacheable requests are marked with CacheStatusHeader, and
+// counted in http_client_cache_requests_total by host and result. Requests
+// with no-store, Range or their own conditional headers bypass the cache. Add
+// it to a Client with WithRoundTrippers.
+func Cache(cfg CacheConfig) Middleware {
+	if cfg.Store == nil {
+		cfg.Store = NewLRUStore(0)
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = defaultCacheMaxBodySize
+	}
+	if cfg.RevalidateTimeout <= 0 {
+		cfg.RevalidateTimeout = defaultCacheRevalidateTimeout
+	}
+	if cfg.Registerer == nil {
+		cfg.Registerer = prometheus.DefaultRegisterer
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	c := &cache{
+		cfg:          cfg,
+		requests:     newCacheRequests(cfg.Registerer),
+		revalidating: make(map[string]bool),
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return c.roundTrip(next, req)
+		})
+	}
+}
+
+func newCacheRequests(reg prometheus.Registerer) *prometheus.CounterVec {
+	return utils.RegisterCollector(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_client_cache_requests_total",
+		Help: "Total number of cacheable outbound http requests by host and cache result.",
+	}, []string{"host", "result"}))
+}
+
+func (c *cache) roundTrip(next http.RoundTripper, req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		res, err := next.RoundTrip(req)
+		if err == nil && isUnsafe(req.Method) && res.StatusCode < http.StatusBadRequest {
+			c.invalidate(req.URL)
+			for _, name := range []string{"Location", "Content-Location"} {
+				location := res.Header.Get(name)
+				if location == "" {
+					continue
+				}
+				// Other hosts aren't invalidated, so they can't be targeted.
+				if u, err := req.URL.Parse(location); err == nil && u.Host == req.URL.Host {
+					c.invalidate(u)
+				}
+			}
+		}
+		return res, err
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok || bypassCache(req) {
+		return next.RoundTrip(req)
+	}
+
+	key := cacheKey(req.Method, req.URL)
+	cached, ok := c.cfg.Store.Get(key)
+	if ok && !cached.matches(req) {
+		cached = nil
+	}
+	if cached != nil {
+		now := c.cfg.Now()
+		resCC := parseCacheControl(cached.Header)
+		age := cached.age(now)
+		lifetime := freshnessLifetime(cached, resCC)
+		reqMaxAge, hasReqMaxAge := seconds(reqCC, "max-age")
+		if hasReqMaxAge && reqMaxAge < lifetime {
+			lifetime = reqMaxAge
+		}
+		_, reqNoCache := reqCC["no-cache"]
+		_, resNoCache := resCC["no-cache"]
+		_, mustRevalidate := resCC["must-revalidate"]
+		switch {
+		case reqNoCache || resNoCache:
+		case age < lifetime:
+			c.record(req, CacheHit)
+			return cached.response(req, CacheHit, age), nil
+		case !mustRevalidate && !hasReqMaxAge:
+			if swr, ok := seconds(resCC, "stale-while-revalidate"); ok && age < lifetime+swr {
+				c.revalidate(next, req, key, cached)
+				c.record(req, CacheStale)
+				return cached.response(req, CacheStale, age), nil
+			}
+		}
+	}
+
+	res, status, err := c.fetch(next, req, key, cached)
+	if err != nil {
+		return nil, err
+	}
+	c.record(req, status)
+	return res, nil
+}
+
+// fetch sends req, conditional if cached has validators, and stores the
+// response.
+func (c *cache) fetch(next http.RoundTripper, req *http.Request, key string, cached *CachedResponse) (*http.Response, string, error) {
+	out := req
+	if cached != nil {
+		etag, lastModified := cached.Header.Get("ETag"), cached.Header.Get("Last-Modified")
+		if etag != "" || lastModified != "" {
+			out = req.Clone(req.Context())
+			if etag != "" {
+				out.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" {
+				out.Header.Set("If-Modified-Since", lastModified)
+			}
+		}
+	}
+
+	res, err := next.RoundTrip(out)
+	if err != nil {
+		return nil, "", err
+	}
+	now := c.cfg.Now()
+	if cached != nil && res.StatusCode == http.StatusNotModified {
+		discardBody(res)
+		updated := cached.revalidated(res.Header, now)
+		c.cfg.Store.Set(key, updated)
+		return updated.response(req, CacheRevalidated, 0), CacheRevalidated, nil
+	}
+
+	res, err = c.store(req, key, res, now)
+	if err != nil {
+		return nil, "", err
+	}
+	res.Header.Set(CacheStatusHeader, CacheMiss)
+	return res, CacheMiss, nil
+}
+
+// store stores res if it's cacheable, and returns it with a replayable body.
+func (c *cache) store(req *http.Request, key string, res *http.Response, now time.Time) (*http.Response, error) {
+	if !isCacheable(req, res) {
+		c.cfg.Store.Delete(key)
+		return res, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, c.cfg.MaxBodySize+1))
+	if err != nil {
+		_ = res.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > c.cfg.MaxBodySize {
+		c.cfg.Store.Delete(key)
+		res.Body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(body), res.Body), Closer: res.Body}
+		return res, nil
+	}
+	_ = res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	c.cfg.Store.Set(key, &CachedResponse{
+		StatusCode:   res.StatusCode,
+		Header:       res.Header.Clone(),
+		Body:         body,
+		Vary:         varyHeader(req, res.Header),
+		ResponseTime: now,
+	})
+	return res, nil
+}
+
+// revalidate refreshes cached in the background, once per key at a time.
+func (c *cache) revalidate(next http.RoundTripper, req *http.Request, key string, cached *CachedResponse) {
+	c.mu.Lock()
+	if c.revalidating[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.revalidating[key] = true
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(detachedContext{parent: req.Context()}, c.cfg.RevalidateTimeout)
+	background := req.Clone(ctx)
+	go func() {
+		defer func() {
+			cancel()
+			c.mu.Lock()
+			delete(c.revalidating, key)
+			c.mu.Unlock()
+		}()
+		res, _, err := c.fetch(next, background, key, cached)
+		if err != nil {
+			logging.FromContext(ctx).Warnw("failed to revalidate cached response",
+				"url", req.URL.String(),
+				"err", err)
+			return
+		}
+		discardBody(res)
+	}()
+}
+
+func (c *cache) record(req *http.Request, status string) {
+	c.requests.WithLabelValues(req.URL.Host, strings.ToLower(status)).Inc()
+}
+
+// invalidate deletes the stored responses of u.
+func (c *cache) invalidate(u *url.URL) {
+	c.cfg.Store.Delete(cacheKey(http.MethodGet, u))
+	c.cfg.Store.Delete(cacheKey(http.MethodHead, u))
+}
+
+// cacheKey is the method with the path and query of u, e.g. "GET /users?page=2".
+func cacheKey(method string, u *url.URL) string {
+	return method + " " + u.RequestURI()
+}
+
+func bypassCache(req *http.Request) bool {
+	for _, name := range []string{"Range", "If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since"} {
+		if req.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func isUnsafe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// isCacheable reports whether res has a status cacheable by default, and a
+// lifetime or validators to reuse it. The response of an authorized request
+// must also be explicitly shareable.
+// See: https://www.rfc-editor.org/rfc/rfc9111#section-3.5
+func isCacheable(req *http.Request, res *http.Response) bool {
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusPermanentRedirect,
+		http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone,
+		http.StatusRequestURITooLong, http.StatusNotImplemented:
+	default:
+		return false
+	}
+	if strings.TrimSpace(res.Header.Get("Vary")) == "*" {
+		return false
+	}
+	cc := parseCacheControl(res.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	if req.Header.Get("Authorization") != "" && !sharedWithAuthorization(cc) {
+		return false
+	}
+	if _, ok := cc["max-age"]; ok {
+		return true
+	}
+	return res.Header.Get("Expires") != "" || res.Header.Get("ETag") != "" || res.Header.Get("Last-Modified") != ""
+}
+
+func sharedWithAuthorization(cc map[string]string) bool {
+	for _, name := range []string{"public", "s-maxage", "must-revalidate"} {
+		if _, ok := cc[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCacheControl returns the directives of the Cache-Control header by
+// lowercase name, with unquoted values.
+func parseCacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+// seconds returns the delta-seconds argument of a directive.
+func seconds(directives map[string]string, name string) (time.Duration, bool) {
+	arg, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// freshnessLifetime returns how long r is fresh: its max-age, else Expires
+// minus Date, else 10% of the time since Last-Modified as heuristic.
+func freshnessLifetime(r *CachedResponse, cc map[string]string) time.Duration {
+	if maxAge, ok := seconds(cc, "max-age"); ok {
+		return maxAge
+	}
+	date := r.ResponseTime
+	if t, err := http.ParseTime(r.Header.Get("Date")); err == nil {
+		date = t
+	}
+	if expires := r.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return t.Sub(date)
+	}
+	if t, err := http.ParseTime(r.Header.Get("Last-Modified")); err == nil && date.After(t) {
+		return date.Sub(t) / 10
+	}
+	return 0
+}
+
+// varyHeader returns the request headers named by the Vary header of a
+// response.
+func varyHeader(req *http.Request, header http.Header) http.Header {
+	vary := http.Header{}
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				vary.Set(name, strings.Join(req.Header.Values(name), ", "))
+			}
+		}
+	}
+	return vary
+}
+
+// matches reports whether req has the headers r varies on.
+func (r *CachedResponse) matches(req *http.Request) bool {
+	for name := range r.Vary {
+		if strings.Join(req.Header.Values(name), ", ") != r.Vary.Get(name) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *CachedResponse) age(now time.Time) time.Duration {
+	age := now.Sub(r.ResponseTime)
+	if n, err := strconv.ParseInt(r.Header.Get("Age"), 10, 64); err == nil && n > 0 {
+		age += time.Duration(n) * time.Second
+	}
+	if age < 0 {
+		return 0
+	}
+	return age
+}
+
+// revalidated returns r updated with the headers of a 304 response.
+func (r *CachedResponse) revalidated(header http.Header, now time.Time) *CachedResponse {
+	updated := *r
+	updated.Header = r.Header.Clone()
+	updated.Header.Del("Age")
+	for name, values := range header {
+		if name == "Content-Length" {
+			continue
+		}
+		updated.Header[name] = values
+	}
+	updated.ResponseTime = now
+	return &updated
+}
+
+func (r *CachedResponse) response(req *http.Request, status string, age time.Duration) *http.Response {
+	header := r.Header.Clone()
+	header.Set(CacheStatusHeader, status)
+	if age > 0 {
+		header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	}
+	body := r.Body
+	if req.Method == http.MethodHead {
+		body = nil
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
+
+func discardBody(res *http.Response) {
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
+}
+
+// detachedContext keeps the values of parent, such as the logger, without its
+// cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
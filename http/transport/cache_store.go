@@ -0,0 +1,101 @@
+package transport
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultCacheEntries = 1000
+
+// CachedResponse is a response stored by the Cache round-tripper.
+type CachedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Vary holds the request headers named by the Vary response header, which
+	// must match for the response to be reused.
+	Vary http.Header
+	// ResponseTime is when the response was received or last revalidated.
+	ResponseTime time.Time
+}
+
+// CacheStore stores the responses of the Cache round-tripper. Stored
+// responses must not be modified; Set replaces them instead.
+type CacheStore interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, res *CachedResponse)
+	Delete(key string)
+}
+
+// LRUStore is an in-memory CacheStore evicting the least recently used
+// responses. It's safe for concurrent use.
+type LRUStore struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type lruEntry struct {
+	key string
+	res *CachedResponse
+}
+
+// NewLRUStore returns a store of up to maxEntries responses, 1000 if not
+// positive.
+func NewLRUStore(maxEntries int) *LRUStore {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheEntries
+	}
+	return &LRUStore{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (s *LRUStore) Get(key string) (*CachedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry).res, true
+}
+
+func (s *LRUStore) Set(key string, res *CachedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.entries[key]; ok {
+		elem.Value.(*lruEntry).res = res
+		s.order.MoveToFront(elem)
+		return
+	}
+	s.entries[key] = s.order.PushFront(&lruEntry{key: key, res: res})
+	for s.order.Len() > s.maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (s *LRUStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.entries[key]; ok {
+		s.order.Remove(elem)
+		delete(s.entries, key)
+	}
+}
+
+// Len returns the number of stored responses.
+func (s *LRUStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
@@ -0,0 +1,219 @@
+package transport
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCache(t *testing.T) {
+	Convey("test cache round-tripper", t, func() {
+		var calls, conditional int32
+		version := "v1"
+		var mu sync.Mutex
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			mu.Lock()
+			etag := `"` + version + `"`
+			mu.Unlock()
+			switch r.URL.Path {
+			case "/fresh":
+				w.Header().Set("Cache-Control", "max-age=60")
+			case "/public":
+				w.Header().Set("Cache-Control", "public, max-age=60")
+			case "/etag":
+				w.Header().Set("Cache-Control", "max-age=10")
+				w.Header().Set("ETag", etag)
+				if r.Header.Get("If-None-Match") == etag {
+					atomic.AddInt32(&conditional, 1)
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+			case "/swr":
+				w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=60")
+				w.Header().Set("ETag", etag)
+			case "/orders":
+				w.Header().Set("Location", "/fresh")
+				w.WriteHeader(http.StatusCreated)
+				return
+			case "/no-store":
+				w.Header().Set("Cache-Control", "no-store")
+			}
+			_, _ = io.WriteString(w, r.URL.Path+" "+etag)
+		}))
+		defer server.Close()
+
+		now := time.Now()
+		var clock sync.Mutex
+		advance := func(d time.Duration) {
+			clock.Lock()
+			defer clock.Unlock()
+			now = now.Add(d)
+		}
+		reg := prometheus.NewRegistry()
+		store := NewLRUStore(0)
+		client := &http.Client{Transport: Chain(nil, Logging(), Cache(CacheConfig{
+			Store:      store,
+			Registerer: reg,
+			Now: func() time.Time {
+				clock.Lock()
+				defer clock.Unlock()
+				return now
+			},
+		}))}
+		get := func(path string, header ...string) (string, string) {
+			req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+			for i := 0; i < len(header); i += 2 {
+				req.Header.Set(header[i], header[i+1])
+			}
+			res, err := client.Do(req)
+			So(err, ShouldBeNil)
+			body, err := io.ReadAll(res.Body)
+			So(err, ShouldBeNil)
+			_ = res.Body.Close()
+			return res.Header.Get(CacheStatusHeader), string(body)
+		}
+
+		Convey("serve fresh responses from the store", func() {
+			status, body := get("/fresh")
+			So(status, ShouldEqual, CacheMiss)
+			advance(30 * time.Second)
+			status, cached := get("/fresh")
+			So(status, ShouldEqual, CacheHit)
+			So(cached, ShouldEqual, body)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+
+			advance(time.Minute)
+			status, _ = get("/fresh")
+			So(status, ShouldEqual, CacheMiss)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+
+			host := strings.TrimPrefix(server.URL, "http://")
+			So(testutil.ToFloat64(newCacheRequests(reg).WithLabelValues(host, "hit")), ShouldEqual, 1)
+			So(testutil.ToFloat64(newCacheRequests(reg).WithLabelValues(host, "miss")), ShouldEqual, 2)
+		})
+
+		Convey("revalidate stale responses with their ETag", func() {
+			get("/etag")
+			advance(time.Minute)
+			status, body := get("/etag")
+			So(status, ShouldEqual, CacheRevalidated)
+			So(body, ShouldEqual, `/etag "v1"`)
+			So(atomic.LoadInt32(&conditional), ShouldEqual, 1)
+
+			status, _ = get("/etag")
+			So(status, ShouldEqual, CacheHit)
+
+			mu.Lock()
+			version = "v2"
+			mu.Unlock()
+			advance(time.Minute)
+			status, body = get("/etag")
+			So(status, ShouldEqual, CacheMiss)
+			So(body, ShouldEqual, `/etag "v2"`)
+		})
+
+		Convey("serve stale responses while revalidating in the background", func() {
+			get("/swr")
+			mu.Lock()
+			version = "v2"
+			mu.Unlock()
+			advance(30 * time.Second)
+			status, body := get("/swr")
+			So(status, ShouldEqual, CacheStale)
+			So(body, ShouldEqual, `/swr "v1"`)
+
+			deadline := time.Now().Add(time.Second)
+			refreshed := func() bool {
+				cached, ok := store.Get("GET /swr")
+				return ok && string(cached.Body) == `/swr "v2"`
+			}
+			for !refreshed() && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			status, body = get("/swr")
+			So(status, ShouldEqual, CacheHit)
+			So(body, ShouldEqual, `/swr "v2"`)
+		})
+
+		Convey("revalidate stale responses when the request sets max-age", func() {
+			get("/swr")
+			mu.Lock()
+			version = "v2"
+			mu.Unlock()
+			advance(30 * time.Second)
+			status, body := get("/swr", "Cache-Control", "max-age=20")
+			So(status, ShouldEqual, CacheMiss)
+			So(body, ShouldEqual, `/swr "v2"`)
+		})
+
+		Convey("store responses of authorized requests only when shared", func() {
+			get("/fresh", "Authorization", "Bearer alice")
+			status, body := get("/fresh", "Authorization", "Bearer bob")
+			So(status, ShouldEqual, CacheMiss)
+			So(body, ShouldEqual, `/fresh "v1"`)
+			So(store.Len(), ShouldEqual, 0)
+
+			get("/public", "Authorization", "Bearer alice")
+			status, _ = get("/public", "Authorization", "Bearer bob")
+			So(status, ShouldEqual, CacheHit)
+		})
+
+		Convey("not store no-store responses", func() {
+			get("/no-store")
+			status, _ := get("/no-store")
+			So(status, ShouldEqual, CacheMiss)
+			So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+			So(store.Len(), ShouldEqual, 0)
+		})
+
+		Convey("invalidate the URL on unsafe requests", func() {
+			get("/fresh")
+			res, err := client.Post(server.URL+"/fresh", "text/plain", strings.NewReader("update"))
+			So(err, ShouldBeNil)
+			_ = res.Body.Close()
+			status, _ := get("/fresh")
+			So(status, ShouldEqual, CacheMiss)
+
+			// Through another host of the same service.
+			res, err = client.Post(strings.Replace(server.URL, "127.0.0.1", "localhost", 1)+"/fresh", "text/plain", strings.NewReader("update"))
+			So(err, ShouldBeNil)
+			_ = res.Body.Close()
+			status, _ = get("/fresh")
+			So(status, ShouldEqual, CacheMiss)
+
+			// And the Location of the response.
+			res, err = client.Post(server.URL+"/orders", "text/plain", strings.NewReader("create"))
+			So(err, ShouldBeNil)
+			_ = res.Body.Close()
+			status, _ = get("/fresh")
+			So(status, ShouldEqual, CacheMiss)
+		})
+	})
+}
+
+func TestLRUStore(t *testing.T) {
+	Convey("evict the least recently used responses", t, func() {
+		store := NewLRUStore(2)
+		store.Set("a", &CachedResponse{})
+		store.Set("b", &CachedResponse{})
+		_, _ = store.Get("a")
+		store.Set("c", &CachedResponse{})
+
+		_, ok := store.Get("b")
+		So(ok, ShouldBeFalse)
+		_, ok = store.Get("a")
+		So(ok, ShouldBeTrue)
+		So(store.Len(), ShouldEqual, 2)
+	})
+}
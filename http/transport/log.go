@@ -0,0 +1,51 @@
+package transport
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Genesic/mixednuts/http/middleware"
+	"github.com/Genesic/mixednuts/logging"
+	"go.uber.org/zap"
+)
+
+const outboundLogMsg = "outbound-req-log"
+
+// Logging logs each round trip with the httpRequest field of LogMiddleware.
+// Failed round trips and 5xx responses are logged as warnings, and responses
+// of the Cache round-tripper have a cache field with their CacheStatusHeader.
+func Logging() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			begin := time.Now()
+			res, err := next.RoundTrip(req)
+
+			requestField := middleware.HttpRequest{
+				Method:    req.Method,
+				URL:       req.URL.String(),
+				ReqSize:   req.ContentLength,
+				UserAgent: req.UserAgent(),
+				Latency:   middleware.FormatLatency(time.Since(begin)),
+			}
+			fields := []zap.Field{zap.String("host", req.URL.Host)}
+			if err != nil {
+				fields = append(fields, zap.Error(err))
+			} else {
+				requestField.Status = res.StatusCode
+				requestField.RespSize = int(res.ContentLength)
+				if status := res.Header.Get(CacheStatusHeader); status != "" {
+					fields = append(fields, zap.String("cache", status))
+				}
+			}
+			fields = append(fields, zap.Any("httpRequest", requestField))
+
+			logger := logging.FromContext(req.Context()).Desugar()
+			if err != nil || res.StatusCode >= http.StatusInternalServerError {
+				logger.Warn(outboundLogMsg, fields...)
+			} else {
+				logger.Info(outboundLogMsg, fields...)
+			}
+			return res, err
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Genesic/mixednuts/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type metrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	return &metrics{
+		requests: utils.RegisterCollector(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_requests_total",
+			Help: "Total number of outbound http requests by host, method and status class.",
+		}, []string{"host", "method", "status"})),
+		duration: utils.RegisterCollector(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_client_request_duration_seconds",
+			Help:    "Latency of outbound http requests by host, method and status class.",
+			Buckets: []float64{0.001, 0.01, 0.1, 0.3, 0.6, 1, 3, 6, 9, 20, 30, 60, 90, 120},
+		}, []string{"host", "method", "status"})),
+		inFlight: utils.RegisterCollector(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_client_requests_in_flight",
+			Help: "Number of outbound http requests in flight by host and method.",
+		}, []string{"host", "method"})),
+	}
+}
+
+// Metrics records the count, latency and in-flight outbound requests in reg,
+// or prometheus.DefaultRegisterer if nil. The status label is the status
+// class, e.g. "2xx", or "error" when no response was received. The latency
+// covers the round trip until the response headers are received.
+func Metrics(reg prometheus.Registerer) Middleware {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	m := newMetrics(reg)
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			host := req.URL.Host
+			inFlight := m.inFlight.WithLabelValues(host, req.Method)
+			inFlight.Inc()
+			defer inFlight.Dec()
+
+			begin := time.Now()
+			res, err := next.RoundTrip(req)
+			status := "error"
+			if err == nil {
+				status = StatusClass(res.StatusCode)
+			}
+			m.requests.WithLabelValues(host, req.Method, status).Inc()
+			m.duration.WithLabelValues(host, req.Method, status).Observe(time.Since(begin).Seconds())
+			return res, err
+		})
+	}
+}
+
+// StatusClass returns the class of an http status code, e.g. "4xx".
+func StatusClass(code int) string {
+	return strconv.Itoa(code/100) + "xx"
+}
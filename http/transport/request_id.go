@@ -0,0 +1,22 @@
+package transport
+
+import (
+	"net/http"
+
+	"github.com/Genesic/mixednuts/logging"
+)
+
+// RequestID forwards the request ID of the request context to the downstream
+// service, unless the request already has one.
+func RequestID() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			requestID, _ := req.Context().Value(logging.RequestIDKey).(string)
+			if requestID != "" && req.Header.Get(logging.RequestIdHeader) == "" {
+				req = req.Clone(req.Context())
+				req.Header.Set(logging.RequestIdHeader, requestID)
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
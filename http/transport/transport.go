@@ -0,0 +1,32 @@
+// Package transport provides http.RoundTripper middlewares for outbound
+// requests, the client side counterpart of the middleware package.
+package transport
+
+import (
+	"net/http"
+)
+
+// Middleware wraps an http.RoundTripper, like mux.MiddlewareFunc wraps an
+// http.Handler. As required by http.RoundTripper, middlewares must not modify
+// the request they receive; they clone it instead.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is an adapter to use a function as an http.RoundTripper.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Chain wraps base with middlewares. The first middleware is the outermost
+// one, so it sees the request first and the response last. A nil base is
+// http.DefaultTransport.
+func Chain(base http.RoundTripper, middlewares ...Middleware) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		base = middlewares[i](base)
+	}
+	return base
+}
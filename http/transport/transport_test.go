@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Genesic/mixednuts/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChain(t *testing.T) {
+	Convey("test round-tripper chain", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Seen-Request-Id", r.Header.Get(logging.RequestIdHeader))
+			w.Header().Set("X-Seen-Order", strings.Join(r.Header.Values("X-Order"), ","))
+			if r.URL.Path == "/fail" {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		}))
+		defer server.Close()
+		host := strings.TrimPrefix(server.URL, "http://")
+
+		order := func(name string) Middleware {
+			return func(next http.RoundTripper) http.RoundTripper {
+				return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					req = req.Clone(req.Context())
+					req.Header.Add("X-Order", name)
+					return next.RoundTrip(req)
+				})
+			}
+		}
+		reg := prometheus.NewRegistry()
+		client := &http.Client{Transport: Chain(nil, RequestID(), Logging(), Metrics(reg), order("first"), order("second"))}
+
+		Convey("run middlewares in order and forward the request ID", func() {
+			ctx := context.WithValue(context.Background(), logging.RequestIDKey, "req-1")
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+			res, err := client.Do(req)
+			So(err, ShouldBeNil)
+			_ = res.Body.Close()
+			So(res.Header.Get("X-Seen-Request-Id"), ShouldEqual, "req-1")
+			So(res.Header.Get("X-Seen-Order"), ShouldEqual, "first,second")
+			So(req.Header.Get(logging.RequestIdHeader), ShouldBeEmpty)
+		})
+
+		Convey("record metrics by status class", func() {
+			for _, path := range []string{"/", "/", "/fail"} {
+				res, err := client.Get(server.URL + path)
+				So(err, ShouldBeNil)
+				_ = res.Body.Close()
+			}
+			_, err := client.Get("http://127.0.0.1:1")
+			So(err, ShouldNotBeNil)
+
+			requests := newMetrics(reg).requests
+			So(testutil.ToFloat64(requests.WithLabelValues(host, http.MethodGet, "2xx")), ShouldEqual, 2)
+			So(testutil.ToFloat64(requests.WithLabelValues(host, http.MethodGet, "5xx")), ShouldEqual, 1)
+			So(testutil.ToFloat64(requests.WithLabelValues("127.0.0.1:1", http.MethodGet, "error")), ShouldEqual, 1)
+			So(testutil.ToFloat64(newMetrics(reg).inFlight.WithLabelValues(host, http.MethodGet)), ShouldEqual, 0)
+		})
+	})
+}
@@ -9,6 +9,8 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -57,14 +59,49 @@ var (
 		EncodeCaller:   zapcore.ShortCallerEncoder,
 	}
 
+	// DefaultLogLevel and DefaultIsDevMode are the config of the default
+	// logger. They are set by SetDefaultConfig; assigning them directly has no
+	// effect on the default logger.
 	DefaultLogLevel  = levelInfo
 	DefaultIsDevMode = false
+
+	// defaultLevel is the level of the default logger, shared by the loggers
+	// derived from it.
+	defaultLevel = zap.NewAtomicLevel()
+	defaultMu    sync.Mutex
+	defaultCache atomic.Pointer[cachedLogger]
 )
 
-// SetDefaultConfig initializes logging module internal state
+// cachedLogger is the default logger and the dev mode it was built for.
+type cachedLogger struct {
+	devMode bool
+	logger  *zap.SugaredLogger
+}
+
+func init() {
+	SetDefaultConfig(DefaultLogLevel, DefaultIsDevMode)
+}
+
+// SetDefaultConfig initializes logging module internal state. The level
+// applies right away to the loggers derived from the default logger, and
+// replaces a level set at runtime through DefaultLevel, even if logLevel is
+// unchanged. The default logger is only rebuilt when the dev mode changes.
 func SetDefaultConfig(logLevel string, isDevMode bool) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
 	DefaultLogLevel = logLevel
 	DefaultIsDevMode = isDevMode
+	defaultLevel.SetLevel(resolveLevel(logLevel, isDevMode))
+	if cached := defaultCache.Load(); cached != nil && cached.devMode == isDevMode {
+		return
+	}
+	defaultCache.Store(&cachedLogger{devMode: isDevMode, logger: newLogger(defaultLevel, isDevMode)})
+}
+
+// DefaultLevel returns the level of the default logger, to change it at
+// runtime, e.g. through its ServeHTTP handler.
+func DefaultLevel() zap.AtomicLevel {
+	return defaultLevel
 }
 
 // WithLogger creates a new context with the provided logger attached.
@@ -96,6 +133,10 @@ func fromContext(ctx context.Context) *zap.SugaredLogger {
 // See https://pkg.go.dev/go.uber.org/zap#example-package-AdvancedConfiguration
 // for how the zap logger is configured.
 func NewLogger(level string, devMode bool) *zap.SugaredLogger {
+	return newLogger(zap.NewAtomicLevelAt(resolveLevel(level, devMode)), devMode)
+}
+
+func resolveLevel(level string, devMode bool) zapcore.Level {
 	if level == "" {
 		if devMode {
 			level = levelDebug
@@ -103,13 +144,15 @@ func NewLogger(level string, devMode bool) *zap.SugaredLogger {
 			level = levelInfo
 		}
 	}
+	return levelToZapLevel(level)
+}
 
-	zapLevel := levelToZapLevel(level)
+func newLogger(level zap.AtomicLevel, devMode bool) *zap.SugaredLogger {
 	normalLevel := zap.LevelEnablerFunc(func(lv zapcore.Level) bool {
 		if stdErrLv(lv) {
 			return false
 		}
-		return lv >= zapLevel
+		return level.Enabled(lv)
 	})
 
 	errorFatalLevel := zap.LevelEnablerFunc(stdErrLv)
@@ -154,8 +197,11 @@ func stdErrLv(lv zapcore.Level) bool {
 	return lv == zapcore.ErrorLevel || lv == zapcore.FatalLevel
 }
 
+// NewDefaultLogger returns the process-wide logger configured by
+// SetDefaultConfig. It's built when the package is initialized, and rebuilt
+// only by SetDefaultConfig.
 func NewDefaultLogger() *zap.SugaredLogger {
-	return NewLogger(DefaultLogLevel, DefaultIsDevMode)
+	return defaultCache.Load().logger
 }
 
 func levelToZapLevel(s string) zapcore.Level {
@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDefaultLogger(t *testing.T) {
+	Convey("test default logger", t, func() {
+		defer SetDefaultConfig(DefaultLogLevel, DefaultIsDevMode)
+		SetDefaultConfig(levelInfo, false)
+		ctx := context.WithValue(context.Background(), RequestIDKey, "req-1")
+
+		Convey("reuse the default logger", func() {
+			So(NewDefaultLogger(), ShouldEqual, NewDefaultLogger())
+			So(fromContext(ctx), ShouldEqual, NewDefaultLogger())
+		})
+
+		Convey("apply level changes to derived loggers", func() {
+			derived := FromContext(ctx).Desugar()
+			So(derived.Core().Enabled(zap.InfoLevel), ShouldBeTrue)
+
+			SetDefaultConfig(levelWarning, false)
+			So(derived.Core().Enabled(zap.InfoLevel), ShouldBeFalse)
+			So(derived.Core().Enabled(zap.WarnLevel), ShouldBeTrue)
+
+			DefaultLevel().SetLevel(zap.DebugLevel)
+			So(derived.Core().Enabled(zap.DebugLevel), ShouldBeTrue)
+
+			// The config resets the level set at runtime, even unchanged.
+			SetDefaultConfig(levelWarning, false)
+			So(derived.Core().Enabled(zap.DebugLevel), ShouldBeFalse)
+		})
+
+		Convey("rebuild the logger when the dev mode changes", func() {
+			logger := NewDefaultLogger()
+			SetDefaultConfig(levelInfo, true)
+			So(NewDefaultLogger(), ShouldNotEqual, logger)
+			So(DefaultLevel().Level(), ShouldEqual, zap.InfoLevel)
+		})
+	})
+}
+
+// BenchmarkFromContext compares the cached default logger with building it on
+// every call, as FromContext used to.
+func BenchmarkFromContext(b *testing.B) {
+	ctx := context.Background()
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = FromContext(ctx)
+		}
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = NewLogger(DefaultLogLevel, DefaultIsDevMode)
+		}
+	})
+}
@@ -0,0 +1,423 @@
+package testutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/Genesic/mixednuts/http/transport"
+)
+
+// RecordCassettesEnv forces cassettes to record again when set to a
+// non-empty value, e.g. RECORD_CASSETTES=1 go test ./...
+const RecordCassettesEnv = "RECORD_CASSETTES"
+
+const redacted = "[REDACTED]"
+
+// CassetteMode is whether a cassette records or replays requests.
+type CassetteMode int
+
+const (
+	// ModeAuto replays the cassette file if it exists, and records it
+	// otherwise.
+	ModeAuto CassetteMode = iota
+	// ModeRecord sends requests upstream and records them, replacing the
+	// cassette file.
+	ModeRecord
+	// ModeReplay only replays the cassette file; requests are never sent.
+	ModeReplay
+)
+
+// Match is a set of request fields compared to find the recorded response of
+// a request.
+type Match int
+
+const (
+	MatchMethod Match = 1 << iota
+	MatchPath
+	MatchQuery
+	// MatchBody compares bodies, JSON ones regardless of formatting and key
+	// order.
+	MatchBody
+
+	MatchDefault = MatchMethod | MatchPath | MatchQuery
+)
+
+var defaultScrubHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+type CassetteOptions struct {
+	Mode CassetteMode
+	// Match defaults to MatchDefault.
+	Match Match
+	// ScrubHeaders are request and response headers redacted before saving,
+	// besides Authorization, Proxy-Authorization, Cookie, Set-Cookie and
+	// X-Api-Key which are always redacted.
+	ScrubHeaders []string
+	// ScrubQuery are query parameters redacted before saving, such as API
+	// keys. They are redacted before matching as well.
+	ScrubQuery []string
+	// Scrub edits each interaction before saving, e.g. to redact bodies.
+	// Bodies are saved as is otherwise.
+	Scrub func(*Interaction)
+}
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       Body        `json:"body,omitempty"`
+}
+
+// Body is saved as a string, or base64 if it's not valid UTF-8.
+type Body []byte
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(map[string][]byte{"base64": b})
+}
+
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = Body(s)
+		return nil
+	}
+	var encoded map[string][]byte
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	*b = encoded["base64"]
+	return nil
+}
+
+// Cassette records the requests of an http client to a file once, and
+// replays them afterwards, so tests don't depend on real upstreams:
+//
+//	cassette := testutils.NewCassette(t, "testdata/users.json", testutils.CassetteOptions{})
+//	client := http.NewClient(usersURL).WithRoundTrippers(cassette.Middleware)
+//
+// Unmatched requests in replay fail the test with the differences to the
+// closest recorded request. Each interaction is replayed at most once: a
+// request replays the first unused interaction it matches, so identical
+// requests replay in the recorded order.
+type Cassette struct {
+	t    testing.TB
+	path string
+	opts CassetteOptions
+	mode CassetteMode
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+}
+
+// NewCassette loads the cassette file at path, or prepares to record it. A
+// recorded cassette is saved when the test ends.
+func NewCassette(t testing.TB, path string, opts CassetteOptions) *Cassette {
+	t.Helper()
+	if opts.Match == 0 {
+		opts.Match = MatchDefault
+	}
+	opts.ScrubHeaders = append(append([]string(nil), defaultScrubHeaders...), opts.ScrubHeaders...)
+
+	c := &Cassette{t: t, path: path, opts: opts, mode: opts.Mode}
+	if os.Getenv(RecordCassettesEnv) != "" {
+		c.mode = ModeRecord
+	}
+	if c.mode == ModeAuto {
+		c.mode = ModeReplay
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			c.mode = ModeRecord
+		}
+	}
+
+	if c.mode == ModeRecord {
+		t.Cleanup(c.save)
+		return c
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read cassette: %s", err)
+	}
+	if err := json.Unmarshal(data, &c.interactions); err != nil {
+		t.Fatalf("failed to decode cassette %s: %s", path, err)
+	}
+	c.used = make([]bool, len(c.interactions))
+	return c
+}
+
+// Recording reports whether the cassette records requests.
+func (c *Cassette) Recording() bool {
+	return c.mode == ModeRecord
+}
+
+// Middleware makes the cassette the transport of a client in place of next,
+// which is only used while recording. It's a transport.Middleware.
+func (c *Cassette) Middleware(next http.RoundTripper) http.RoundTripper {
+	return transport.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return c.roundTrip(next, req)
+	})
+}
+
+// RoundTrip records or replays req, recording with http.DefaultTransport.
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	return c.roundTrip(http.DefaultTransport, req)
+}
+
+func (c *Cassette) roundTrip(next http.RoundTripper, req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if c.mode == ModeRecord {
+		return c.record(next, req, body)
+	}
+	return c.replay(req, body)
+}
+
+func (c *Cassette) record(next http.RoundTripper, req *http.Request, body []byte) (*http.Response, error) {
+	res, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resBody, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(resBody))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interactions = append(c.interactions, &Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: req.Header.Clone(),
+			Body:   body,
+		},
+		Response: RecordedResponse{
+			StatusCode: res.StatusCode,
+			Header:     res.Header.Clone(),
+			Body:       resBody,
+		},
+	})
+	return res, nil
+}
+
+func (c *Cassette) replay(req *http.Request, body []byte) (*http.Response, error) {
+	got := RecordedRequest{Method: req.Method, URL: c.scrubURL(req.URL.String()), Body: body}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	closest, closestDiff := -1, []string(nil)
+	for i, interaction := range c.interactions {
+		if c.used[i] {
+			continue
+		}
+		diff := c.diff(interaction.Request, got)
+		if len(diff) == 0 {
+			c.used[i] = true
+			return interaction.Response.response(req), nil
+		}
+		if closest < 0 || len(diff) < len(closestDiff) {
+			closest, closestDiff = i, diff
+		}
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "cassette %s: no recorded request matches %s %s", c.path, req.Method, got.URL)
+	if closest >= 0 {
+		fmt.Fprintf(&msg, "\nclosest is interaction #%d:\n%s", closest+1, strings.Join(closestDiff, "\n"))
+	} else {
+		msg.WriteString("\nall recorded requests were replayed")
+	}
+	c.t.Errorf("%s", msg.String())
+	return nil, errors.New(msg.String())
+}
+
+// diff returns the differences of got to want on the matched fields.
+func (c *Cassette) diff(want, got RecordedRequest) []string {
+	var diff []string
+	field := func(name, want, got string) {
+		if want != got {
+			diff = append(diff, fmt.Sprintf("  %s: want %q, got %q", name, want, got))
+		}
+	}
+
+	wantURL, _ := url.Parse(want.URL)
+	gotURL, _ := url.Parse(got.URL)
+	if c.opts.Match&MatchMethod != 0 {
+		field("method", want.Method, got.Method)
+	}
+	if c.opts.Match&MatchPath != 0 {
+		field("path", wantURL.Path, gotURL.Path)
+	}
+	if c.opts.Match&MatchQuery != 0 {
+		field("query", wantURL.Query().Encode(), gotURL.Query().Encode())
+	}
+	if c.opts.Match&MatchBody != 0 {
+		wantBody, gotBody := canonicalBody(want.Body), canonicalBody(got.Body)
+		if wantBody != gotBody {
+			diff = append(diff, "  body:\n"+lineDiff(wantBody, gotBody))
+		}
+	}
+	return diff
+}
+
+// save scrubs the recorded interactions and writes the cassette file.
+func (c *Cassette) save() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, interaction := range c.interactions {
+		interaction.Request.URL = c.scrubURL(interaction.Request.URL)
+		c.scrubHeader(interaction.Request.Header)
+		c.scrubHeader(interaction.Response.Header)
+		if c.opts.Scrub != nil {
+			c.opts.Scrub(interaction)
+		}
+	}
+
+	data, err := json.MarshalIndent(c.interactions, "", "  ")
+	if err != nil {
+		c.t.Errorf("failed to encode cassette %s: %s", c.path, err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		c.t.Errorf("failed to save cassette: %s", err)
+		return
+	}
+	if err := os.WriteFile(c.path, append(data, '\n'), 0o644); err != nil {
+		c.t.Errorf("failed to save cassette: %s", err)
+	}
+}
+
+func (c *Cassette) scrubHeader(header http.Header) {
+	for _, name := range c.opts.ScrubHeaders {
+		if header.Get(name) != "" {
+			header.Set(name, redacted)
+		}
+	}
+}
+
+func (c *Cassette) scrubURL(raw string) string {
+	if len(c.opts.ScrubQuery) == 0 {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	query := u.Query()
+	for _, name := range c.opts.ScrubQuery {
+		if query.Has(name) {
+			query.Set(name, redacted)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (r RecordedResponse) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        r.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
+
+// readBody reads the body of req and replaces it, so it can still be sent.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// canonicalBody indents JSON bodies, so they compare regardless of formatting
+// and key order.
+func canonicalBody(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return string(body)
+	}
+	indented, _ := json.MarshalIndent(v, "", "  ")
+	return string(indented)
+}
+
+// lineDiff returns the lines of want missing in got prefixed with "-", and the
+// lines of got missing in want prefixed with "+".
+func lineDiff(want, got string) string {
+	a, b := strings.Split(want, "\n"), strings.Split(got, "\n")
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, "      "+a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "    - "+a[i])
+			i++
+		default:
+			lines = append(lines, "    + "+b[j])
+			j++
+		}
+	}
+	return strings.Join(lines, "\n")
+}
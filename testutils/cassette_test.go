@@ -0,0 +1,97 @@
+package testutils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	httpApp "github.com/Genesic/mixednuts/http"
+	"github.com/Genesic/mixednuts/http/auth"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// errorRecorder records the errors reported to a test instead of failing it.
+type errorRecorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *errorRecorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestCassette(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret")
+		_, _ = fmt.Fprintf(w, `{"id":%q}`, r.URL.Query().Get("id"))
+	}))
+	path := filepath.Join(t.TempDir(), "cassettes", "users.json")
+	ctx := context.Background()
+
+	// Records once, the cassette being saved when the subtest ends.
+	t.Run("record", func(t *testing.T) {
+		Convey("record a cassette without file", t, func() {
+			cassette := NewCassette(t, path, CassetteOptions{ScrubHeaders: []string{"X-Tenant"}, ScrubQuery: []string{"api_key"}})
+			So(cassette.Recording(), ShouldBeTrue)
+			client := httpApp.NewClient(server.URL).
+				WithAuth(auth.Bearer("token")).
+				WithHeaders("X-Tenant", "acme").
+				WithRoundTrippers(cassette.Middleware)
+			user, err := httpApp.Get[map[string]string](ctx, client, "/users", httpApp.Query(url.Values{"id": {"1"}, "api_key": {"key"}}))
+			So(err, ShouldBeNil)
+			So(user["id"], ShouldEqual, "1")
+		})
+	})
+	server.Close()
+
+	Convey("test cassette replay", t, func() {
+		Convey("scrub sensitive headers and query parameters", func() {
+			data, err := os.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldNotContainSubstring, "token")
+			So(string(data), ShouldNotContainSubstring, "secret")
+			So(string(data), ShouldNotContainSubstring, "acme")
+			So(string(data), ShouldNotContainSubstring, "api_key=key")
+			So(string(data), ShouldContainSubstring, redacted)
+		})
+
+		Convey("replay recorded responses without the upstream", func() {
+			cassette := NewCassette(t, path, CassetteOptions{ScrubQuery: []string{"api_key"}})
+			So(cassette.Recording(), ShouldBeFalse)
+			client := httpApp.NewClient(server.URL).WithRoundTrippers(cassette.Middleware)
+			user, err := httpApp.Get[map[string]string](ctx, client, "/users", httpApp.Query(url.Values{"id": {"1"}, "api_key": {"other"}}))
+			So(err, ShouldBeNil)
+			So(user["id"], ShouldEqual, "1")
+		})
+
+		Convey("fail unmatched requests with a diff", func() {
+			recorder := &errorRecorder{TB: t}
+			cassette := NewCassette(recorder, path, CassetteOptions{})
+			client := httpApp.NewClient(server.URL).WithRoundTrippers(cassette.Middleware)
+			_, err := httpApp.Get[map[string]string](ctx, client, "/users", httpApp.Query(url.Values{"id": {"2"}}))
+			So(err, ShouldNotBeNil)
+			So(recorder.errors, ShouldHaveLength, 1)
+			So(recorder.errors[0], ShouldContainSubstring, "closest is interaction #1")
+			So(recorder.errors[0], ShouldContainSubstring, `query: want "api_key=%5BREDACTED%5D&id=1", got "id=2"`)
+		})
+	})
+
+	Convey("diff bodies line by line", t, func() {
+		diff := lineDiff(canonicalBody([]byte(`{"a":1,"b":2}`)), canonicalBody([]byte(`{"b":2, "a":3}`)))
+		So(strings.Split(diff, "\n"), ShouldResemble, []string{
+			"      {",
+			`    -   "a": 1,`,
+			`    +   "a": 3,`,
+			`        "b": 2`,
+			"      }",
+		})
+	})
+}